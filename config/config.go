@@ -0,0 +1,495 @@
+// Package config loads the application settings. Values are layered, each
+// source overriding the previous one: built-in defaults, an optional YAML or
+// TOML file, the environment (including an optional .env file) and finally
+// command-line flags.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Server   Server
+	Database Database
+	JWT      JWT
+	Password Password
+	Login    Login
+	OIDC     OIDC
+	Account  Account
+	Worker   Worker
+}
+
+type Server struct {
+	Address      string
+	LogFile      string
+	DrainTimeout time.Duration
+	// JobTimeout bounds how long a handler waits for the worker pool.
+	JobTimeout   time.Duration
+	AdminUserIDs []uint
+	// TrustedProxies may set X-Forwarded-For; requests from anywhere else
+	// are attributed to their remote address.
+	TrustedProxies []string
+}
+
+type Database struct {
+	Host     string
+	User     string
+	Password string
+	Name     string
+	Port     string
+	SSLMode  string
+	TimeZone string
+}
+
+func (d Database) DSN() string {
+	return fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s TimeZone=%s", d.Host, d.User, d.Password, d.Name, d.Port, d.SSLMode, d.TimeZone)
+}
+
+type JWT struct {
+	// PrivateKey is the HS256 secret that signs new tokens. Tokens signed with
+	// one of PreviousKeys are still accepted, so the key can be rotated
+	// without logging users out.
+	PrivateKey   string
+	PreviousKeys []string
+	// KeyFile is a PEM private key (RSA, P-256 or Ed25519) that signs new
+	// tokens with RS256, ES256 or EdDSA instead of PrivateKey.
+	// PreviousKeyFiles are PEM private or public keys still accepted.
+	KeyFile          string
+	PreviousKeyFiles []string
+	TokenTTL         time.Duration
+	RefreshTokenTTL  time.Duration
+	Issuer           string
+	Audience         string
+	// ClockSkew is the leeway allowed when checking exp, nbf and iat.
+	ClockSkew time.Duration
+	// RevocationBackend stores revoked tokens: memory or postgres.
+	RevocationBackend string
+	// MFATokenTTL is how long the token handed out after the password step
+	// of a two-factor login can be exchanged with a code.
+	MFATokenTTL time.Duration
+}
+
+// Password selects how new password hashes are computed. Stored hashes made
+// with other parameters still verify and are upgraded on the next login.
+type Password struct {
+	Algorithm         string
+	BcryptCost        int
+	Argon2Memory      int
+	Argon2Iterations  int
+	Argon2Parallelism int
+	// New passwords need MinLength to MaxLength characters from at least
+	// MinClasses of lowercase, uppercase, digits and symbols, and must not
+	// be listed in DenylistFile, one common or breached password per line.
+	MinLength    int
+	MaxLength    int
+	MinClasses   int
+	DenylistFile string
+	// ResetTokenTTL is how long a password reset token can be used.
+	// ResetNotifier delivers the tokens: log, or file to append them to
+	// ResetNotifierFile.
+	ResetTokenTTL     time.Duration
+	ResetNotifier     string
+	ResetNotifierFile string
+	// ResetMaxRequests reset requests for one username, or
+	// ResetIPMaxRequests from one client IP, within ResetRequestWindow hold
+	// off further ones until the window has passed; 0 does not limit them.
+	ResetMaxRequests   int
+	ResetIPMaxRequests int
+	ResetRequestWindow time.Duration
+}
+
+// Login limits failed logins, counted per user and per client IP over
+// AttemptWindow. After a failure the next attempt has to wait BaseDelay,
+// doubled for every further failure up to MaxDelay; reaching MaxAttempts, or
+// IPMaxAttempts for an IP, locks it out for LockoutDuration.
+type Login struct {
+	MaxAttempts     int
+	IPMaxAttempts   int
+	AttemptWindow   time.Duration
+	LockoutDuration time.Duration
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+}
+
+// OIDC enables login through an external OpenID Connect provider; it is off
+// while Issuer is empty. ProviderName names the provider in the
+// /auth/oidc/:provider routes and in linked identities. StateTTL is how long
+// a started login may take to come back to RedirectURL.
+type OIDC struct {
+	ProviderName string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	StateTTL     time.Duration
+}
+
+// Account sets what happens to the tasks of a deleted user: DeletionMode
+// cascade soft-deletes them with the user, reassign hands them to the user
+// ReassignTo.
+type Account struct {
+	DeletionMode string
+	ReassignTo   uint
+}
+
+type Worker struct {
+	QueueBackend           string
+	QueueCapacity          int
+	QueuePollInterval      time.Duration
+	QueueVisibilityTimeout time.Duration
+	MinWorkers             int
+	MaxWorkers             int
+	IdleTimeout            time.Duration
+	ScaleUpLatency         time.Duration
+	MaxInFlightPerUser     int
+	RetryAfter             time.Duration
+}
+
+func Default() Config {
+	return Config{
+		Server: Server{
+			Address:      ":8000",
+			LogFile:      "app.log",
+			DrainTimeout: 30 * time.Second,
+			JobTimeout:   10 * time.Second,
+		},
+		Database: Database{
+			Port:     "5432",
+			SSLMode:  "disable",
+			TimeZone: "Africa/Lagos",
+		},
+		JWT: JWT{
+			TokenTTL:        15 * time.Minute,
+			RefreshTokenTTL: 30 * 24 * time.Hour,
+			MFATokenTTL:     5 * time.Minute,
+			Issuer:          "konzek_assg",
+			Audience:        "konzek_assg",
+			ClockSkew:       30 * time.Second,
+
+			RevocationBackend: "memory",
+		},
+		Password: Password{
+			Algorithm:         "argon2id",
+			BcryptCost:        10,
+			Argon2Memory:      64 * 1024,
+			Argon2Iterations:  3,
+			Argon2Parallelism: 2,
+			MinLength:         10,
+			MaxLength:         128,
+			MinClasses:        2,
+			ResetTokenTTL:     30 * time.Minute,
+			ResetNotifier:     "log",
+			ResetNotifierFile: "password_resets.log",
+
+			ResetMaxRequests:   3,
+			ResetIPMaxRequests: 10,
+			ResetRequestWindow: time.Hour,
+		},
+		Login: Login{
+			MaxAttempts:     5,
+			IPMaxAttempts:   20,
+			AttemptWindow:   15 * time.Minute,
+			LockoutDuration: 15 * time.Minute,
+			BaseDelay:       time.Second,
+			MaxDelay:        30 * time.Second,
+		},
+		OIDC: OIDC{
+			ProviderName: "oidc",
+			Scopes:       []string{"openid", "profile", "email"},
+			StateTTL:     10 * time.Minute,
+		},
+		Account: Account{
+			DeletionMode: "cascade",
+		},
+		Worker: Worker{
+			QueueBackend:           "memory",
+			QueueCapacity:          100,
+			QueuePollInterval:      time.Second,
+			QueueVisibilityTimeout: 5 * time.Minute,
+			MinWorkers:             5,
+			MaxWorkers:             20,
+			IdleTimeout:            30 * time.Second,
+			ScaleUpLatency:         500 * time.Millisecond,
+			MaxInFlightPerUser:     10,
+			RetryAfter:             time.Second,
+		},
+	}
+}
+
+// Load builds the configuration from args (usually os.Args[1:]) and the
+// process environment. The config file is taken from -config or CONFIG_FILE;
+// a .env file in the working directory is read if present, without
+// overriding variables that are already set.
+func Load(args []string) (*Config, error) {
+	if err := godotenv.Load(".env"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("config: reading .env: %w", err)
+	}
+	return load(args, os.LookupEnv)
+}
+
+func load(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	cfg := Default()
+
+	flags := flag.NewFlagSet("konzek_assg", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	configFile := flags.String("config", "", "path to a YAML or TOML config file (env CONFIG_FILE)")
+	values := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		values[s.flag] = flags.String(s.flag, "", fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+
+	path := *configFile
+	if path == "" {
+		path, _ = lookupEnv("CONFIG_FILE")
+	}
+	if path != "" {
+		fileValues, err := readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range settings {
+			if value, ok := fileValues[s.key]; ok {
+				if err := s.set(&cfg, value); err != nil {
+					return nil, fmt.Errorf("config: %s in %s: %w", s.key, path, err)
+				}
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := lookupEnv(s.env); ok {
+			if err := s.set(&cfg, value); err != nil {
+				return nil, fmt.Errorf("config: %s: %w", s.env, err)
+			}
+		}
+	}
+
+	var flagErr error
+	flags.Visit(func(f *flag.Flag) {
+		s, ok := settingsByFlag[f.Name]
+		if !ok || flagErr != nil {
+			return
+		}
+		if err := s.set(&cfg, *values[f.Name]); err != nil {
+			flagErr = fmt.Errorf("config: -%s: %w", f.Name, err)
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Args returns the positional arguments left after the flags Load accepts,
+// for example a subcommand name.
+func Args(args []string) []string {
+	flags := flag.NewFlagSet("konzek_assg", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.String("config", "", "")
+	for _, s := range settings {
+		flags.String(s.flag, "", "")
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil
+	}
+	return flags.Args()
+}
+
+// Usage describes every setting with its flag and environment variable.
+func Usage() string {
+	var b strings.Builder
+	b.WriteString("  -config string\n\tpath to a YAML or TOML config file (env CONFIG_FILE)\n")
+	for _, s := range settings {
+		fmt.Fprintf(&b, "  -%s string\n\t%s (env %s, file key %s)\n", s.flag, s.usage, s.env, s.key)
+	}
+	return b.String()
+}
+
+// readFile flattens a YAML or TOML file into "section.key" strings.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+
+	raw := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("config: unsupported config file type %q, use .yaml, .yml or .toml", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config: parsing %s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	flatten("", raw, values)
+	for key := range values {
+		if _, ok := settingsByKey[key]; !ok {
+			return nil, fmt.Errorf("config: unknown key %q in %s", key, path)
+		}
+	}
+	return values, nil
+}
+
+func flatten(prefix string, raw map[string]interface{}, values map[string]string) {
+	for key, value := range raw {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			flatten(key, v, values)
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+}
+
+// Validate reports every invalid or missing value at once.
+func (c *Config) Validate() error {
+	var problems []string
+	require := func(value, env string) {
+		if strings.TrimSpace(value) == "" {
+			problems = append(problems, fmt.Sprintf("%s is required", env))
+		}
+	}
+	require(c.Database.Host, "DB_HOST")
+	require(c.Database.User, "DB_USER")
+	require(c.Database.Name, "DB_NAME")
+	require(c.Database.Port, "DB_PORT")
+	if c.JWT.KeyFile == "" {
+		require(c.JWT.PrivateKey, "JWT_PRIVATE_KEY or JWT_KEY_FILE")
+	}
+	require(c.Server.Address, "HTTP_ADDRESS")
+
+	if c.JWT.TokenTTL <= 0 {
+		problems = append(problems, "TOKEN_TTL must be positive")
+	}
+	if c.JWT.RefreshTokenTTL <= 0 {
+		problems = append(problems, "REFRESH_TOKEN_TTL must be positive")
+	}
+	if c.JWT.MFATokenTTL <= 0 {
+		problems = append(problems, "MFA_TOKEN_TTL must be positive")
+	}
+	if c.JWT.ClockSkew < 0 {
+		problems = append(problems, "JWT_CLOCK_SKEW must not be negative")
+	}
+	if c.Server.DrainTimeout <= 0 {
+		problems = append(problems, "DRAIN_TIMEOUT must be positive")
+	}
+	if c.Server.JobTimeout <= 0 {
+		problems = append(problems, "JOB_TIMEOUT must be positive")
+	}
+	switch c.JWT.RevocationBackend {
+	case "memory", "postgres":
+	default:
+		problems = append(problems, fmt.Sprintf("REVOCATION_BACKEND must be memory or postgres, got %q", c.JWT.RevocationBackend))
+	}
+	switch c.Password.Algorithm {
+	case "bcrypt", "argon2id":
+	default:
+		problems = append(problems, fmt.Sprintf("PASSWORD_ALGORITHM must be bcrypt or argon2id, got %q", c.Password.Algorithm))
+	}
+	if c.Password.BcryptCost < 4 || c.Password.BcryptCost > 31 {
+		problems = append(problems, fmt.Sprintf("PASSWORD_BCRYPT_COST must be between 4 and 31, got %d", c.Password.BcryptCost))
+	}
+	if c.Password.Argon2Memory < 8*c.Password.Argon2Parallelism || c.Password.Argon2Iterations < 1 || c.Password.Argon2Parallelism < 1 || c.Password.Argon2Parallelism > 255 {
+		problems = append(problems, "PASSWORD_ARGON2_* need iterations >= 1, 1 <= parallelism <= 255 and memory >= 8 KiB per thread")
+	}
+	if c.Login.MaxAttempts < 0 || c.Login.IPMaxAttempts < 0 {
+		problems = append(problems, "LOGIN_MAX_ATTEMPTS and LOGIN_IP_MAX_ATTEMPTS must not be negative")
+	}
+	if c.Login.AttemptWindow <= 0 || c.Login.LockoutDuration <= 0 {
+		problems = append(problems, "LOGIN_ATTEMPT_WINDOW and LOGIN_LOCKOUT_DURATION must be positive")
+	}
+	if c.Login.BaseDelay < 0 || c.Login.MaxDelay < c.Login.BaseDelay {
+		problems = append(problems, "need 0 <= LOGIN_BASE_DELAY <= LOGIN_MAX_DELAY")
+	}
+	if c.Password.MinLength < 1 || c.Password.MaxLength < c.Password.MinLength {
+		problems = append(problems, fmt.Sprintf("need 1 <= PASSWORD_MIN_LENGTH <= PASSWORD_MAX_LENGTH, got %d and %d", c.Password.MinLength, c.Password.MaxLength))
+	}
+	if c.Password.MinClasses < 0 || c.Password.MinClasses > 4 {
+		problems = append(problems, fmt.Sprintf("PASSWORD_MIN_CLASSES must be between 0 and 4, got %d", c.Password.MinClasses))
+	}
+	if c.Password.ResetTokenTTL <= 0 {
+		problems = append(problems, "PASSWORD_RESET_TOKEN_TTL must be positive")
+	}
+	if c.Password.ResetMaxRequests < 0 || c.Password.ResetIPMaxRequests < 0 {
+		problems = append(problems, "PASSWORD_RESET_MAX_REQUESTS and PASSWORD_RESET_IP_MAX_REQUESTS must not be negative")
+	}
+	if c.Password.ResetRequestWindow <= 0 {
+		problems = append(problems, "PASSWORD_RESET_REQUEST_WINDOW must be positive")
+	}
+	switch c.Password.ResetNotifier {
+	case "log":
+	case "file":
+		require(c.Password.ResetNotifierFile, "PASSWORD_RESET_NOTIFIER_FILE")
+	default:
+		problems = append(problems, fmt.Sprintf("PASSWORD_RESET_NOTIFIER must be log or file, got %q", c.Password.ResetNotifier))
+	}
+	if c.OIDC.Issuer != "" {
+		require(c.OIDC.ProviderName, "OIDC_PROVIDER_NAME")
+		require(c.OIDC.ClientID, "OIDC_CLIENT_ID")
+		require(c.OIDC.RedirectURL, "OIDC_REDIRECT_URL")
+		if c.OIDC.StateTTL <= 0 {
+			problems = append(problems, "OIDC_STATE_TTL must be positive")
+		}
+	}
+	switch c.Account.DeletionMode {
+	case "cascade":
+	case "reassign":
+		if c.Account.ReassignTo == 0 {
+			problems = append(problems, "ACCOUNT_REASSIGN_TO is required with ACCOUNT_DELETION_MODE reassign")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("ACCOUNT_DELETION_MODE must be cascade or reassign, got %q", c.Account.DeletionMode))
+	}
+	switch c.Worker.QueueBackend {
+	case "memory", "postgres":
+	default:
+		problems = append(problems, fmt.Sprintf("QUEUE_BACKEND must be memory or postgres, got %q", c.Worker.QueueBackend))
+	}
+	if c.Worker.QueueCapacity < 0 {
+		problems = append(problems, "QUEUE_CAPACITY must not be negative")
+	}
+	if c.Worker.MinWorkers < 1 || c.Worker.MaxWorkers < c.Worker.MinWorkers {
+		problems = append(problems, fmt.Sprintf("need 1 <= WORKER_MIN <= WORKER_MAX, got %d and %d", c.Worker.MinWorkers, c.Worker.MaxWorkers))
+	}
+	if c.Worker.MaxInFlightPerUser < 0 {
+		problems = append(problems, "WORKER_MAX_IN_FLIGHT_PER_USER must not be negative")
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("config: invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+}
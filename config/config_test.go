@@ -0,0 +1,75 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func env(values map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := values[key]
+		return value, ok
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	file := `
+database:
+  host: file-host
+  user: file-user
+  name: file-db
+worker:
+  min_workers: 2
+  max_workers: 4
+jwt:
+  private_key: file-key
+  token_ttl: 1h
+`
+	if err := os.WriteFile(path, []byte(file), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := load(
+		[]string{"-config", path, "-worker-max", "8"},
+		env(map[string]string{"DB_HOST": "env-host", "WORKER_MAX": "6", "TOKEN_TTL": "60"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Database.User != "file-user" {
+		t.Errorf("file value not applied: %q", cfg.Database.User)
+	}
+	if cfg.Database.Host != "env-host" {
+		t.Errorf("environment must override the file: %q", cfg.Database.Host)
+	}
+	if cfg.Worker.MaxWorkers != 8 {
+		t.Errorf("flags must override the environment: %d", cfg.Worker.MaxWorkers)
+	}
+	if cfg.JWT.TokenTTL != time.Minute {
+		t.Errorf("TOKEN_TTL in seconds not parsed: %v", cfg.JWT.TokenTTL)
+	}
+	if cfg.Server.Address != ":8000" {
+		t.Errorf("default not kept: %q", cfg.Server.Address)
+	}
+}
+
+func TestLoadReportsAllProblems(t *testing.T) {
+	_, err := load(nil, env(map[string]string{"WORKER_MIN": "0", "ACCOUNT_DELETION_MODE": "reassign"}))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{"DB_HOST is required", "JWT_PRIVATE_KEY or JWT_KEY_FILE is required", "WORKER_MIN", "ACCOUNT_REASSIGN_TO is required"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+
+	if _, err := load(nil, env(map[string]string{"WORKER_MIN": "two"})); err == nil || !strings.Contains(err.Error(), "WORKER_MIN") {
+		t.Errorf("expected a parse error naming WORKER_MIN, got %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting binds one configuration value to its file key, environment
+// variable and command-line flag.
+type setting struct {
+	key   string
+	env   string
+	flag  string
+	usage string
+	set   func(*Config, string) error
+}
+
+var settings = []setting{
+	stringSetting("server.address", "HTTP_ADDRESS", "http-address", "address the HTTP server listens on", func(c *Config) *string { return &c.Server.Address }),
+	stringSetting("server.log_file", "LOG_FILE", "log-file", "file the application log is appended to", func(c *Config) *string { return &c.Server.LogFile }),
+	durationSetting("server.drain_timeout", "DRAIN_TIMEOUT", "drain-timeout", "time allowed for requests and jobs to finish on shutdown", func(c *Config) *time.Duration { return &c.Server.DrainTimeout }),
+	durationSetting("server.job_timeout", "JOB_TIMEOUT", "job-timeout", "time a request waits for the worker pool", func(c *Config) *time.Duration { return &c.Server.JobTimeout }),
+	uintListSetting("server.admin_user_ids", "ADMIN_USER_IDS", "admin-user-ids", "comma-separated IDs of users granted the admin role at startup", func(c *Config) *[]uint { return &c.Server.AdminUserIDs }),
+	stringListSetting("server.trusted_proxies", "TRUSTED_PROXIES", "trusted-proxies", "comma-separated proxy IPs or CIDRs allowed to set X-Forwarded-For", func(c *Config) *[]string { return &c.Server.TrustedProxies }),
+
+	stringSetting("database.host", "DB_HOST", "db-host", "database host", func(c *Config) *string { return &c.Database.Host }),
+	stringSetting("database.user", "DB_USER", "db-user", "database user", func(c *Config) *string { return &c.Database.User }),
+	stringSetting("database.password", "DB_PASSWORD", "db-password", "database password", func(c *Config) *string { return &c.Database.Password }),
+	stringSetting("database.name", "DB_NAME", "db-name", "database name", func(c *Config) *string { return &c.Database.Name }),
+	stringSetting("database.port", "DB_PORT", "db-port", "database port", func(c *Config) *string { return &c.Database.Port }),
+	stringSetting("database.sslmode", "DB_SSLMODE", "db-sslmode", "Postgres sslmode", func(c *Config) *string { return &c.Database.SSLMode }),
+	stringSetting("database.timezone", "DB_TIMEZONE", "db-timezone", "database session time zone", func(c *Config) *string { return &c.Database.TimeZone }),
+
+	stringSetting("jwt.private_key", "JWT_PRIVATE_KEY", "jwt-private-key", "secret used to sign tokens", func(c *Config) *string { return &c.JWT.PrivateKey }),
+	stringListSetting("jwt.previous_keys", "JWT_PREVIOUS_KEYS", "jwt-previous-keys", "comma-separated retired secrets whose tokens are still accepted", func(c *Config) *[]string { return &c.JWT.PreviousKeys }),
+	stringSetting("jwt.key_file", "JWT_KEY_FILE", "jwt-key-file", "PEM private key for RS256, ES256 or EdDSA signing instead of JWT_PRIVATE_KEY", func(c *Config) *string { return &c.JWT.KeyFile }),
+	stringListSetting("jwt.previous_key_files", "JWT_PREVIOUS_KEY_FILES", "jwt-previous-key-files", "comma-separated PEM keys of retired key pairs whose tokens are still accepted", func(c *Config) *[]string { return &c.JWT.PreviousKeyFiles }),
+	secondsSetting("jwt.token_ttl", "TOKEN_TTL", "token-ttl", "access token lifetime, in seconds or as a duration", func(c *Config) *time.Duration { return &c.JWT.TokenTTL }),
+	durationSetting("jwt.refresh_token_ttl", "REFRESH_TOKEN_TTL", "refresh-token-ttl", "refresh token lifetime", func(c *Config) *time.Duration { return &c.JWT.RefreshTokenTTL }),
+	durationSetting("jwt.mfa_token_ttl", "MFA_TOKEN_TTL", "mfa-token-ttl", "time allowed to enter the second factor after the password", func(c *Config) *time.Duration { return &c.JWT.MFATokenTTL }),
+	stringSetting("jwt.issuer", "JWT_ISSUER", "jwt-issuer", "iss claim of issued tokens", func(c *Config) *string { return &c.JWT.Issuer }),
+	stringSetting("jwt.audience", "JWT_AUDIENCE", "jwt-audience", "aud claim of issued tokens", func(c *Config) *string { return &c.JWT.Audience }),
+	durationSetting("jwt.clock_skew", "JWT_CLOCK_SKEW", "jwt-clock-skew", "leeway when checking token times", func(c *Config) *time.Duration { return &c.JWT.ClockSkew }),
+	stringSetting("jwt.revocation_backend", "REVOCATION_BACKEND", "revocation-backend", "revoked token store: memory or postgres", func(c *Config) *string { return &c.JWT.RevocationBackend }),
+
+	stringSetting("password.algorithm", "PASSWORD_ALGORITHM", "password-algorithm", "hash for new passwords: argon2id or bcrypt", func(c *Config) *string { return &c.Password.Algorithm }),
+	intSetting("password.bcrypt_cost", "PASSWORD_BCRYPT_COST", "password-bcrypt-cost", "bcrypt cost factor", func(c *Config) *int { return &c.Password.BcryptCost }),
+	intSetting("password.argon2_memory", "PASSWORD_ARGON2_MEMORY", "password-argon2-memory", "argon2id memory in KiB", func(c *Config) *int { return &c.Password.Argon2Memory }),
+	intSetting("password.argon2_iterations", "PASSWORD_ARGON2_ITERATIONS", "password-argon2-iterations", "argon2id passes over memory", func(c *Config) *int { return &c.Password.Argon2Iterations }),
+	intSetting("password.argon2_parallelism", "PASSWORD_ARGON2_PARALLELISM", "password-argon2-parallelism", "argon2id threads", func(c *Config) *int { return &c.Password.Argon2Parallelism }),
+	intSetting("password.min_length", "PASSWORD_MIN_LENGTH", "password-min-length", "minimum number of characters in new passwords", func(c *Config) *int { return &c.Password.MinLength }),
+	intSetting("password.max_length", "PASSWORD_MAX_LENGTH", "password-max-length", "maximum number of characters in new passwords", func(c *Config) *int { return &c.Password.MaxLength }),
+	intSetting("password.min_classes", "PASSWORD_MIN_CLASSES", "password-min-classes", "character classes (lowercase, uppercase, digits, symbols) new passwords must mix", func(c *Config) *int { return &c.Password.MinClasses }),
+	stringSetting("password.denylist_file", "PASSWORD_DENYLIST_FILE", "password-denylist-file", "file of common or breached passwords, one per line, that are refused", func(c *Config) *string { return &c.Password.DenylistFile }),
+	durationSetting("password.reset_token_ttl", "PASSWORD_RESET_TOKEN_TTL", "password-reset-token-ttl", "lifetime of password reset tokens", func(c *Config) *time.Duration { return &c.Password.ResetTokenTTL }),
+	stringSetting("password.reset_notifier", "PASSWORD_RESET_NOTIFIER", "password-reset-notifier", "how reset tokens are delivered: log or file", func(c *Config) *string { return &c.Password.ResetNotifier }),
+	stringSetting("password.reset_notifier_file", "PASSWORD_RESET_NOTIFIER_FILE", "password-reset-notifier-file", "file the file notifier appends reset tokens to", func(c *Config) *string { return &c.Password.ResetNotifierFile }),
+	intSetting("password.reset_max_requests", "PASSWORD_RESET_MAX_REQUESTS", "password-reset-max-requests", "reset requests for one username per window, 0 for no limit", func(c *Config) *int { return &c.Password.ResetMaxRequests }),
+	intSetting("password.reset_ip_max_requests", "PASSWORD_RESET_IP_MAX_REQUESTS", "password-reset-ip-max-requests", "reset requests from one client IP per window, 0 for no limit", func(c *Config) *int { return &c.Password.ResetIPMaxRequests }),
+	durationSetting("password.reset_request_window", "PASSWORD_RESET_REQUEST_WINDOW", "password-reset-request-window", "time over which reset requests are counted", func(c *Config) *time.Duration { return &c.Password.ResetRequestWindow }),
+
+	intSetting("login.max_attempts", "LOGIN_MAX_ATTEMPTS", "login-max-attempts", "failed logins that lock a username out, 0 for no lockout", func(c *Config) *int { return &c.Login.MaxAttempts }),
+	intSetting("login.ip_max_attempts", "LOGIN_IP_MAX_ATTEMPTS", "login-ip-max-attempts", "failed logins that lock a client IP out, 0 for no lockout", func(c *Config) *int { return &c.Login.IPMaxAttempts }),
+	durationSetting("login.attempt_window", "LOGIN_ATTEMPT_WINDOW", "login-attempt-window", "time after which failed logins are forgotten", func(c *Config) *time.Duration { return &c.Login.AttemptWindow }),
+	durationSetting("login.lockout_duration", "LOGIN_LOCKOUT_DURATION", "login-lockout-duration", "how long a lockout lasts", func(c *Config) *time.Duration { return &c.Login.LockoutDuration }),
+	durationSetting("login.base_delay", "LOGIN_BASE_DELAY", "login-base-delay", "wait after the first failed login, doubled for each further one", func(c *Config) *time.Duration { return &c.Login.BaseDelay }),
+	durationSetting("login.max_delay", "LOGIN_MAX_DELAY", "login-max-delay", "longest wait between failed logins", func(c *Config) *time.Duration { return &c.Login.MaxDelay }),
+
+	stringSetting("oidc.provider_name", "OIDC_PROVIDER_NAME", "oidc-provider-name", "name of the OpenID Connect provider in login URLs", func(c *Config) *string { return &c.OIDC.ProviderName }),
+	stringSetting("oidc.issuer", "OIDC_ISSUER", "oidc-issuer", "issuer URL of the OpenID Connect provider, empty to disable external login", func(c *Config) *string { return &c.OIDC.Issuer }),
+	stringSetting("oidc.client_id", "OIDC_CLIENT_ID", "oidc-client-id", "client ID registered with the OpenID Connect provider", func(c *Config) *string { return &c.OIDC.ClientID }),
+	stringSetting("oidc.client_secret", "OIDC_CLIENT_SECRET", "oidc-client-secret", "client secret, empty for a public client", func(c *Config) *string { return &c.OIDC.ClientSecret }),
+	stringSetting("oidc.redirect_url", "OIDC_REDIRECT_URL", "oidc-redirect-url", "callback URL registered with the provider", func(c *Config) *string { return &c.OIDC.RedirectURL }),
+	stringListSetting("oidc.scopes", "OIDC_SCOPES", "oidc-scopes", "comma-separated scopes requested from the provider", func(c *Config) *[]string { return &c.OIDC.Scopes }),
+	durationSetting("oidc.state_ttl", "OIDC_STATE_TTL", "oidc-state-ttl", "time allowed to complete a login at the provider", func(c *Config) *time.Duration { return &c.OIDC.StateTTL }),
+
+	stringSetting("account.deletion_mode", "ACCOUNT_DELETION_MODE", "account-deletion-mode", "what happens to a deleted user's tasks: cascade or reassign", func(c *Config) *string { return &c.Account.DeletionMode }),
+	uintSetting("account.reassign_to", "ACCOUNT_REASSIGN_TO", "account-reassign-to", "ID of the user given the tasks of deleted users in reassign mode", func(c *Config) *uint { return &c.Account.ReassignTo }),
+
+	stringSetting("worker.queue_backend", "QUEUE_BACKEND", "queue-backend", "job queue: memory or postgres", func(c *Config) *string { return &c.Worker.QueueBackend }),
+	intSetting("worker.queue_capacity", "QUEUE_CAPACITY", "queue-capacity", "maximum number of queued jobs", func(c *Config) *int { return &c.Worker.QueueCapacity }),
+	durationSetting("worker.queue_poll_interval", "QUEUE_POLL_INTERVAL", "queue-poll-interval", "how often idle workers poll the postgres queue", func(c *Config) *time.Duration { return &c.Worker.QueuePollInterval }),
+	durationSetting("worker.queue_visibility_timeout", "QUEUE_VISIBILITY_TIMEOUT", "queue-visibility-timeout", "lease after which an unacknowledged postgres job is redelivered", func(c *Config) *time.Duration { return &c.Worker.QueueVisibilityTimeout }),
+	intSetting("worker.min_workers", "WORKER_MIN", "worker-min", "minimum number of workers", func(c *Config) *int { return &c.Worker.MinWorkers }),
+	intSetting("worker.max_workers", "WORKER_MAX", "worker-max", "maximum number of workers", func(c *Config) *int { return &c.Worker.MaxWorkers }),
+	durationSetting("worker.idle_timeout", "WORKER_IDLE_TIMEOUT", "worker-idle-timeout", "idle time after which extra workers are retired", func(c *Config) *time.Duration { return &c.Worker.IdleTimeout }),
+	durationSetting("worker.scale_up_latency", "WORKER_SCALE_UP_LATENCY", "worker-scale-up-latency", "queueing latency that makes the pool grow", func(c *Config) *time.Duration { return &c.Worker.ScaleUpLatency }),
+	intSetting("worker.max_in_flight_per_user", "WORKER_MAX_IN_FLIGHT_PER_USER", "worker-max-in-flight-per-user", "jobs one user may have queued or running, 0 for no limit", func(c *Config) *int { return &c.Worker.MaxInFlightPerUser }),
+	durationSetting("worker.retry_after", "WORKER_RETRY_AFTER", "worker-retry-after", "Retry-After suggested to rate-limited users", func(c *Config) *time.Duration { return &c.Worker.RetryAfter }),
+}
+
+var (
+	settingsByKey  = map[string]setting{}
+	settingsByFlag = map[string]setting{}
+)
+
+func init() {
+	for _, s := range settings {
+		settingsByKey[s.key] = s
+		settingsByFlag[s.flag] = s
+	}
+}
+
+func stringSetting(key, env, flag, usage string, field func(*Config) *string) setting {
+	return setting{key, env, flag, usage, func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}}
+}
+
+func intSetting(key, env, flag, usage string, field func(*Config) *int) setting {
+	return setting{key, env, flag, usage, func(c *Config, value string) error {
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		*field(c) = n
+		return nil
+	}}
+}
+
+func uintSetting(key, env, flag, usage string, field func(*Config) *uint) setting {
+	return setting{key, env, flag, usage, func(c *Config, value string) error {
+		n, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an ID", value)
+		}
+		*field(c) = uint(n)
+		return nil
+	}}
+}
+
+func durationSetting(key, env, flag, usage string, field func(*Config) *time.Duration) setting {
+	return setting{key, env, flag, usage, func(c *Config, value string) error {
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 30s or 5m", value)
+		}
+		*field(c) = d
+		return nil
+	}}
+}
+
+// secondsSetting accepts a plain number of seconds, as TOKEN_TTL always has,
+// or a duration.
+func secondsSetting(key, env, flag, usage string, field func(*Config) *time.Duration) setting {
+	return setting{key, env, flag, usage, func(c *Config, value string) error {
+		value = strings.TrimSpace(value)
+		if seconds, err := strconv.Atoi(value); err == nil {
+			*field(c) = time.Duration(seconds) * time.Second
+			return nil
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is neither a number of seconds nor a duration", value)
+		}
+		*field(c) = d
+		return nil
+	}}
+}
+
+func stringListSetting(key, env, flag, usage string, field func(*Config) *[]string) setting {
+	return setting{key, env, flag, usage, func(c *Config, value string) error {
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*field(c) = list
+		return nil
+	}}
+}
+
+func uintListSetting(key, env, flag, usage string, field func(*Config) *[]uint) setting {
+	return setting{key, env, flag, usage, func(c *Config, value string) error {
+		var list []uint
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			n, err := strconv.ParseUint(item, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%q is not a list of IDs", value)
+			}
+			list = append(list, uint(n))
+		}
+		*field(c) = list
+		return nil
+	}}
+}
@@ -0,0 +1,193 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"konzek_assg/model"
+	"konzek_assg/worker"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// pagination reads ?limit= and ?offset=, clamping them to sane values.
+func pagination(c *gin.Context) (int, int) {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	offset, err := strconv.Atoi(c.Query("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+func deadLetterID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Invalid dead letter ID",
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func respondWithDeadLetterError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	message := "internal server error"
+	switch {
+	case errors.Is(err, model.ErrDeadLetterNotFound):
+		status = http.StatusNotFound
+		message = err.Error()
+	case errors.Is(err, worker.ErrAlreadyReplayed):
+		status = http.StatusConflict
+		message = err.Error()
+	case errors.Is(err, worker.ErrQueueClosed):
+		status = http.StatusServiceUnavailable
+		message = err.Error()
+	default:
+		logger.Println("Error handling dead letter:", err)
+	}
+	errorResponse := model.ErrorResponse{
+		StatusCode: status,
+		Message:    message,
+	}
+	c.JSON(status, errorResponse)
+}
+
+func ListDeadLettersHandler(c *gin.Context) {
+	start := time.Now()
+	limit, offset := pagination(c)
+	includeReplayed, _ := strconv.ParseBool(c.Query("include_replayed"))
+
+	deadLetters, err := model.ListDeadLetters(includeReplayed, limit, offset)
+	if err != nil {
+		respondWithDeadLetterError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Dead letters queried successfully",
+		Data:       deadLetters,
+	}
+	c.JSON(http.StatusOK, successResponse)
+	observeRequestDuration(c, start)
+}
+
+func GetDeadLetterHandler(c *gin.Context) {
+	start := time.Now()
+	id, ok := deadLetterID(c)
+	if !ok {
+		return
+	}
+
+	deadLetter, err := model.FindDeadLetter(id)
+	if err != nil {
+		respondWithDeadLetterError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Dead letter queried successfully",
+		Data:       deadLetter,
+	}
+	c.JSON(http.StatusOK, successResponse)
+	observeRequestDuration(c, start)
+}
+
+func ReplayDeadLetterHandler(c *gin.Context) {
+	start := time.Now()
+	id, ok := deadLetterID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), jobTimeout)
+	defer cancel()
+	job, err := worker.ReplayDeadLetter(ctx, pool, id)
+	if err != nil {
+		respondWithDeadLetterError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	if job.Track {
+		c.Header("Location", "/api/jobs/"+job.ID)
+	}
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusAccepted,
+		Message:    "Dead letter replayed",
+		Data:       gin.H{"job_id": job.ID},
+	}
+	c.JSON(http.StatusAccepted, successResponse)
+	observeRequestDuration(c, start)
+}
+
+type ResizePoolInput struct {
+	MinWorkers int `json:"min_workers" binding:"required"`
+	MaxWorkers int `json:"max_workers" binding:"required"`
+}
+
+func GetPoolHandler(c *gin.Context) {
+	start := time.Now()
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Worker pool queried successfully",
+		Data:       pool.Stats(c.Request.Context()),
+	}
+	c.JSON(http.StatusOK, successResponse)
+	observeRequestDuration(c, start)
+}
+
+func ResizePoolHandler(c *gin.Context) {
+	start := time.Now()
+	var input ResizePoolInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+
+	if err := pool.Resize(input.MinWorkers, input.MaxWorkers); err != nil {
+		status := http.StatusUnprocessableEntity
+		if errors.Is(err, worker.ErrPoolStopped) {
+			status = http.StatusServiceUnavailable
+		}
+		errorResponse := model.ErrorResponse{
+			StatusCode: status,
+			Message:    err.Error(),
+		}
+		c.JSON(status, errorResponse)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Worker pool resized successfully",
+		Data:       pool.Stats(c.Request.Context()),
+	}
+	c.JSON(http.StatusOK, successResponse)
+	observeRequestDuration(c, start)
+}
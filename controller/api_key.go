@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"errors"
+	"konzek_assg/helper"
+	"konzek_assg/model"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CreateAPIKeyInput struct {
+	Name   string   `json:"name" binding:"required"`
+	Scopes []string `json:"scopes" binding:"required"`
+	// ExpiresAt is optional; keys without it work until deleted.
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func respondWithAPIKeyError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	message := "internal server error"
+	switch {
+	case errors.Is(err, model.ErrAPIKeyNotFound):
+		status = http.StatusNotFound
+		message = err.Error()
+	case errors.Is(err, model.ErrInvalidAPIKeyScope):
+		status = http.StatusUnprocessableEntity
+		message = err.Error()
+	default:
+		logger.Println("Error handling api key:", err)
+	}
+	errorResponse := model.ErrorResponse{
+		StatusCode: status,
+		Message:    message,
+	}
+	c.JSON(status, errorResponse)
+}
+
+// CreateAPIKeyHandler creates an API key for the caller. The key itself is
+// only in this response; afterwards just its prefix is known.
+func CreateAPIKeyHandler(c *gin.Context) {
+	start := time.Now()
+	principal, err := helper.CurrentPrincipal(c)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+	var input CreateAPIKeyInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(time.Now()) {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusUnprocessableEntity,
+			Message:    "expires_at must be in the future",
+		}
+		c.JSON(http.StatusUnprocessableEntity, errorResponse)
+		return
+	}
+
+	key, prefix, hash, err := helper.NewAPIKey()
+	if err != nil {
+		respondWithAPIKeyError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+	apiKey := model.APIKey{
+		UserID:    principal.UserID,
+		Name:      input.Name,
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Scopes:    input.Scopes,
+		ExpiresAt: input.ExpiresAt,
+	}
+	if err := model.CreateAPIKey(&apiKey); err != nil {
+		respondWithAPIKeyError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	logger.Printf("User %d created api key %s.\n", principal.UserID, apiKey.Prefix)
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusCreated,
+		Message:    "API key created. Store it now, it is not shown again.",
+		Data:       gin.H{"key": key, "api_key": apiKey},
+	}
+	c.JSON(http.StatusCreated, successResponse)
+	observeRequestDuration(c, start)
+}
+
+func ListAPIKeysHandler(c *gin.Context) {
+	start := time.Now()
+	principal, err := helper.CurrentPrincipal(c)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+
+	keys, err := model.ListAPIKeys(principal.UserID)
+	if err != nil {
+		respondWithAPIKeyError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "API keys queried successfully",
+		Data:       keys,
+	}
+	c.JSON(http.StatusOK, successResponse)
+	observeRequestDuration(c, start)
+}
+
+func DeleteAPIKeyHandler(c *gin.Context) {
+	start := time.Now()
+	principal, err := helper.CurrentPrincipal(c)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Invalid API key ID",
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+
+	if err := model.DeleteAPIKey(principal.UserID, uint(id)); err != nil {
+		respondWithAPIKeyError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	logger.Printf("User %d deleted api key %d.\n", principal.UserID, id)
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "API key deleted successfully",
+	}
+	c.JSON(http.StatusOK, successResponse)
+	observeRequestDuration(c, start)
+}
@@ -1,12 +1,17 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
 	"konzek_assg/database"
 	"konzek_assg/helper"
+	"konzek_assg/lockout"
 	"konzek_assg/model"
+	"konzek_assg/password"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -36,6 +41,13 @@ func Register(context *gin.Context, logger *log.Logger) {
 		return
 	}
 
+	if err := password.CheckPolicy(input.Password, input.Username); err != nil {
+		respondWithPolicyError(context, err, logger)
+		return
+	}
+
+	user := model.User{Username: input.Username, PlainPassword: input.Password}
+
 	tx := database.Database.Begin()
 	defer func() {
 		if r := recover(); r != nil {
@@ -44,10 +56,6 @@ func Register(context *gin.Context, logger *log.Logger) {
 		}
 	}()
 
-	user := model.User{
-		Username: input.Username,
-		Password: input.Password,
-	}
 	savedUser, err := user.SaveInTransaction(tx)
 	if err != nil {
 		tx.Rollback()
@@ -94,25 +102,213 @@ func Login(context *gin.Context, logger *log.Logger) {
 
 	user, err := model.FindUserByUsername(input.Username)
 	if err != nil {
+		logger.Println("Error finding user: ", err)
 		errorResponse := model.ErrorResponse{
 			StatusCode: http.StatusInternalServerError,
-			Message:    "User not found",
+			Message:    "internal server error",
 		}
-		context.JSON(http.StatusNotFound, errorResponse)
+		context.JSON(http.StatusInternalServerError, errorResponse)
 		return
 	}
 
-	err = user.ValidatePassword(input.Password)
+	attempt := model.LoginAttempt{
+		Username:  input.Username,
+		UserID:    user.ID,
+		IP:        context.ClientIP(),
+		UserAgent: context.Request.UserAgent(),
+	}
+	reservation, wait, err := lockout.Reserve(attempt)
 	if err != nil {
+		logger.Println("Error checking login attempts: ", err)
 		errorResponse := model.ErrorResponse{
 			StatusCode: http.StatusInternalServerError,
+			Message:    "internal server error",
+		}
+		context.JSON(http.StatusInternalServerError, errorResponse)
+		return
+	}
+	if wait > 0 {
+		attempt.Reason = model.LoginLockedOut
+		recordFailedLogin(attempt, logger)
+		context.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusTooManyRequests,
+			Message:    "Too many failed login attempts, try again later",
+		}
+		context.JSON(http.StatusTooManyRequests, errorResponse)
+		return
+	}
+
+	// Unknown users and wrong passwords get the same answer after the same
+	// amount of hashing work, so usernames cannot be probed.
+	if user.ID == 0 {
+		password.VerifyNothing(input.Password)
+		attempt.Reason = model.LoginUnknownUser
+	} else if err := user.ValidatePassword(input.Password); err != nil {
+		attempt.Reason = model.LoginWrongPassword
+	}
+	if attempt.Reason != "" {
+		recordFailedLogin(attempt, logger)
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusUnauthorized,
 			Message:    "Invalid username or password",
 		}
 		context.JSON(http.StatusUnauthorized, errorResponse)
 		return
 	}
+	releaseAttempt(reservation, logger)
+	if rehashed, err := user.RehashPassword(input.Password); err != nil {
+		logger.Printf("Failed to rehash password of user %s: %v\n", input.Username, err)
+	} else if rehashed {
+		logger.Printf("Password of user %s rehashed with the current parameters.\n", input.Username)
+	}
 
-	jwt, err := helper.GenerateJWT(user)
+	continueLogin(context, user, logger)
+}
+
+// continueLogin finishes a login whose first factor was checked: users with
+// two-factor authentication get an mfa token, everyone else is logged in.
+func continueLogin(context *gin.Context, user model.User, logger *log.Logger) {
+	enabled, err := model.MFAEnabled(user.ID)
+	if err != nil {
+		logger.Println("Error checking two-factor authentication: ", err)
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusInternalServerError,
+			Message:    "internal server error",
+		}
+		context.JSON(http.StatusInternalServerError, errorResponse)
+		return
+	}
+	if enabled {
+		mfaToken, err := helper.GenerateMFAToken(user)
+		if err != nil {
+			logger.Println("Error issuing mfa token: ", err)
+			errorResponse := model.ErrorResponse{
+				StatusCode: http.StatusInternalServerError,
+				Message:    "Failed to generate jwt. ",
+			}
+			context.JSON(http.StatusInternalServerError, errorResponse)
+			return
+		}
+		logger.Printf("User %s passed the first step, waiting for the second factor.\n", user.Username)
+		successResponse := model.SuccessResponse{
+			StatusCode: http.StatusOK,
+			Message:    "Second factor required, post a code with the mfa_token to /auth/mfa/verify.",
+			Data:       gin.H{"mfa_required": true, "mfa_token": mfaToken},
+		}
+		context.JSON(http.StatusOK, successResponse)
+		return
+	}
+
+	completeLogin(context, user, logger)
+}
+
+// completeLogin answers a login whose every factor was checked with an
+// access and a refresh token.
+func completeLogin(context *gin.Context, user model.User, logger *log.Logger) {
+	if err := lockout.Succeed(user.ID); err != nil {
+		logger.Println("Error resetting failed login attempts: ", err)
+	}
+
+	refreshToken, err := helper.IssueRefreshToken(user)
+	if err != nil {
+		logger.Println("Error issuing refresh token: ", err)
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusInternalServerError,
+			Message:    "Failed to generate jwt. ",
+		}
+		context.JSON(http.StatusInternalServerError, errorResponse)
+		return
+	}
+	tokens, err := tokenResponse(user, refreshToken)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusInternalServerError,
+			Message:    "Failed to generate jwt. ",
+		}
+		context.JSON(http.StatusInternalServerError, errorResponse)
+		return
+	}
+
+	logger.Printf("User %s logged in successfully.", user.Username)
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    fmt.Sprintf("User %s logged in successfully.", user.Username),
+		Data:       tokens,
+	}
+	context.JSON(http.StatusOK, successResponse)
+}
+
+// releaseAttempt takes back the failure lockout.Reserve counted for an
+// attempt that turned out to be right.
+func releaseAttempt(reservation lockout.Reservation, logger *log.Logger) {
+	if err := reservation.Release(); err != nil {
+		logger.Println("Error releasing login attempt: ", err)
+	}
+}
+
+// recordFailedLogin audits the attempt and, if it was one too many, locks
+// its user and IP out.
+func recordFailedLogin(attempt model.LoginAttempt, logger *log.Logger) {
+	logger.Printf("Failed login for %q from %s: %s\n", attempt.Username, attempt.IP, attempt.Reason)
+	if err := lockout.Fail(attempt); err != nil {
+		logger.Println("Error recording failed login: ", err)
+	}
+}
+
+func RefreshHandler(logger *log.Logger) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		Refresh(context, logger)
+	}
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh
+// token. Each refresh token works once; replaying a spent one revokes every
+// token descended from the same login.
+func Refresh(context *gin.Context, logger *log.Logger) {
+	var input model.RefreshInput
+
+	if err := context.ShouldBindJSON(&input); err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		context.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+
+	refreshToken, userID, err := helper.RotateRefreshToken(input.RefreshToken)
+	if err != nil {
+		status := http.StatusUnauthorized
+		message := err.Error()
+		switch {
+		case errors.Is(err, model.ErrRefreshTokenReused):
+			logger.Println("Refresh token reused, revoked its family.")
+		case errors.Is(err, model.ErrRefreshTokenInvalid), errors.Is(err, model.ErrRefreshTokenExpired):
+		default:
+			logger.Println("Error rotating refresh token: ", err)
+			status = http.StatusInternalServerError
+			message = "internal server error"
+		}
+		errorResponse := model.ErrorResponse{
+			StatusCode: status,
+			Message:    message,
+		}
+		context.JSON(status, errorResponse)
+		return
+	}
+
+	user, err := model.FindUserWithRoles(userID)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusUnauthorized,
+			Message:    model.ErrRefreshTokenInvalid.Error(),
+		}
+		context.JSON(http.StatusUnauthorized, errorResponse)
+		return
+	}
+
+	tokens, err := tokenResponse(user, refreshToken)
 	if err != nil {
 		errorResponse := model.ErrorResponse{
 			StatusCode: http.StatusInternalServerError,
@@ -122,12 +318,118 @@ func Login(context *gin.Context, logger *log.Logger) {
 		return
 	}
 
-	logger.Printf("User %s logged in successfully.", input.Username)
 	successResponse := model.SuccessResponse{
 		StatusCode: http.StatusOK,
-		Message:    fmt.Sprintf("User %s logged in successfully.", input.Username),
-		Data:       gin.H{"jwt": jwt},
+		Message:    "Token refreshed successfully.",
+		Data:       tokens,
+	}
+	context.JSON(http.StatusOK, successResponse)
+}
+
+// tokenResponse pairs a fresh access token with refreshToken.
+func tokenResponse(user model.User, refreshToken string) (gin.H, error) {
+	jwt, err := helper.GenerateJWT(user)
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"jwt":           jwt,
+		"refresh_token": refreshToken,
+		"expires_in":    int(helper.AccessTokenTTL().Seconds()),
+	}, nil
+}
+
+func LogoutHandler(logger *log.Logger) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		Logout(context, logger)
+	}
+}
+
+func LogoutAllHandler(logger *log.Logger) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		LogoutAll(context, logger)
+	}
+}
+
+// Logout revokes the access token of the request and, if given, the refresh
+// token issued with it.
+func Logout(context *gin.Context, logger *log.Logger) {
+	principal, err := helper.CurrentPrincipal(context)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusUnauthorized,
+			Message:    "Authentication required",
+		}
+		context.JSON(http.StatusUnauthorized, errorResponse)
+		return
+	}
+
+	var input model.LogoutInput
+	if context.Request.ContentLength != 0 {
+		if err := context.ShouldBindJSON(&input); err != nil {
+			errorResponse := model.ErrorResponse{
+				StatusCode: http.StatusBadRequest,
+				Message:    err.Error(),
+			}
+			context.JSON(http.StatusBadRequest, errorResponse)
+			return
+		}
+	}
+
+	if err := helper.RevokeToken(context.Request.Context(), principal.Claims); err != nil {
+		logger.Println("Error revoking token: ", err)
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusInternalServerError,
+			Message:    "internal server error",
+		}
+		context.JSON(http.StatusInternalServerError, errorResponse)
+		return
+	}
+	if input.RefreshToken != "" {
+		if err := helper.RevokeRefreshToken(input.RefreshToken); err != nil {
+			logger.Println("Error revoking refresh token: ", err)
+			errorResponse := model.ErrorResponse{
+				StatusCode: http.StatusInternalServerError,
+				Message:    "internal server error",
+			}
+			context.JSON(http.StatusInternalServerError, errorResponse)
+			return
+		}
+	}
+
+	logger.Printf("User %s logged out.\n", principal.Username)
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Logged out successfully.",
 	}
 	context.JSON(http.StatusOK, successResponse)
+}
 
+// LogoutAll revokes every access and refresh token of the current user.
+func LogoutAll(context *gin.Context, logger *log.Logger) {
+	principal, err := helper.CurrentPrincipal(context)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusUnauthorized,
+			Message:    "Authentication required",
+		}
+		context.JSON(http.StatusUnauthorized, errorResponse)
+		return
+	}
+	if err := helper.RevokeAllSessions(context.Request.Context(), principal.UserID); err != nil {
+		logger.Println("Error revoking sessions: ", err)
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusInternalServerError,
+			Message:    "internal server error",
+		}
+		context.JSON(http.StatusInternalServerError, errorResponse)
+		return
+	}
+
+	logger.Printf("User %s logged out of all sessions.\n", principal.Username)
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Logged out of all sessions successfully.",
+	}
+	context.JSON(http.StatusOK, successResponse)
 }
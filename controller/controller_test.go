@@ -2,26 +2,45 @@ package controller_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"konzek_assg/config"
 	"konzek_assg/controller"
 	"konzek_assg/database"
 	"konzek_assg/helper"
+	"konzek_assg/middleware"
 	"konzek_assg/model"
+	"konzek_assg/oidc"
+	"konzek_assg/oidc/oidctest"
 	"konzek_assg/worker"
 
 	"github.com/gin-gonic/gin"
 )
 
+func connectDatabase(t *testing.T) {
+	cfg, err := config.Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := helper.Configure(cfg.JWT); err != nil {
+		t.Fatal(err)
+	}
+	database.Connect(cfg.Database)
+}
+
 func TestRegisterHandler(t *testing.T) {
-	database.Connect()
+	connectDatabase(t)
 	var buf bytes.Buffer
 	logger := log.New(&buf, "", log.Ldate|log.Ltime)
 
@@ -67,15 +86,44 @@ func TestRegisterHandler(t *testing.T) {
 
 }
 
+// A password that looks like a hash is hashed like any other, so clients
+// cannot choose the stored hash and skip the password policy.
+func TestRegisterHashesHashLookingPasswords(t *testing.T) {
+	connectDatabase(t)
+	logger := log.New(&bytes.Buffer{}, "", 0)
+
+	username := "hash-looking-user"
+	supplied := "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"
+	defer database.Database.Exec("DELETE FROM users WHERE username = $1", username)
+
+	body, _ := json.Marshal(model.AuthenticationInput{Username: username, Password: supplied})
+	rr := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rr)
+	c.Request = httptest.NewRequest("POST", "/register", bytes.NewBuffer(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	controller.RegisterHandler(logger)(c)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("register: status %d: %s", rr.Code, rr.Body)
+	}
+
+	user, err := model.FindUserByUsername(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Password == supplied {
+		t.Fatal("hash-looking password stored as is")
+	}
+	if err := user.ValidatePassword(supplied); err != nil {
+		t.Errorf("the supplied string is not the password: %v", err)
+	}
+}
+
 func TestLoginHandler(t *testing.T) {
-	database.Connect()
+	connectDatabase(t)
 	var buf bytes.Buffer
 	logger := log.New(&buf, "", log.Ldate|log.Ltime)
 
-	user := model.User{
-		Username: "testuser345",
-		Password: "testpassword2",
-	}
+	user := model.User{Username: "testuser345", PlainPassword: "testpassword2"}
 	if err := database.Database.Create(&user).Error; err != nil {
 		t.Fatalf("Failed to create user: %v", err)
 	}
@@ -96,25 +144,28 @@ func TestLoginHandler(t *testing.T) {
 	if rr.Code != http.StatusOK {
 		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
 	}
-	jwtT, err := helper.GenerateJWT(user)
-	if err != nil {
-		t.Errorf("Error in generating jwt.")
+	var actualBody struct {
+		StatusCode int                    `json:"status_code"`
+		Message    string                 `json:"message"`
+		Data       map[string]interface{} `json:"data"`
 	}
-	expectedBody := model.SuccessResponse{
-		StatusCode: http.StatusOK,
-		Message:    "User " + user.Username + " logged in successfully.",
-		Data: map[string]interface{}{
-			"jwt": jwtT,
-		},
-	}
-
-	var actualBody model.SuccessResponse
 	if err := json.Unmarshal(rr.Body.Bytes(), &actualBody); err != nil {
 		t.Fatal(err)
 	}
+	if want := "User " + user.Username + " logged in successfully."; actualBody.Message != want {
+		t.Errorf("handler returned unexpected message: got %q want %q", actualBody.Message, want)
+	}
 
-	if !reflect.DeepEqual(expectedBody, actualBody) {
-		t.Errorf("handler returned unexpected body: got %+v want %+v", actualBody, expectedBody)
+	token, _ := actualBody.Data["jwt"].(string)
+	claims, err := helper.ParseToken(token)
+	if err != nil {
+		t.Fatalf("handler returned an invalid token: %v", err)
+	}
+	if id, _ := claims.UserID(); id != user.ID {
+		t.Errorf("token subject is %q, want %d", claims.Subject, user.ID)
+	}
+	if refreshToken, _ := actualBody.Data["refresh_token"].(string); refreshToken == "" {
+		t.Errorf("handler returned no refresh token")
 	}
 
 	if buf.Len() == 0 {
@@ -128,7 +179,7 @@ func TestLoginHandler(t *testing.T) {
 }
 
 func TestCreateTask(t *testing.T) {
-	database.Connect()
+	connectDatabase(t)
 
 	var buf bytes.Buffer
 	logger := log.New(&buf, "", log.Ldate|log.Ltime)
@@ -137,17 +188,20 @@ func TestCreateTask(t *testing.T) {
 		UserID:      1,
 		Title:       "Test title",
 		Description: "Test description",
-		Status:      "Create",
+		Status:      model.StatusTodo,
 	}
-	err := worker.CreateTask(task, logger)
+	created, err := worker.CreateTask(task, logger)
 	if err != nil {
 		t.Errorf("Error in creating task: %v", err)
 	}
+	if created.ID == 0 {
+		t.Errorf("created task has no ID")
+	}
 	logger.Println("Task is created successfully.")
 }
 
 func TestDeleteTask(t *testing.T) {
-	database.Connect()
+	connectDatabase(t)
 
 	var buf bytes.Buffer
 	logger := log.New(&buf, "", log.Ldate|log.Ltime)
@@ -156,7 +210,7 @@ func TestDeleteTask(t *testing.T) {
 		UserID:      1,
 		Title:       "Test title",
 		Description: "Test description",
-		Status:      "Create",
+		Status:      model.StatusTodo,
 	}
 
 	if err := database.Database.Create(&task).Error; err != nil {
@@ -172,7 +226,7 @@ func TestDeleteTask(t *testing.T) {
 }
 
 func TestUpdateTask(t *testing.T) {
-	database.Connect()
+	connectDatabase(t)
 
 	var buf bytes.Buffer
 	logger := log.New(&buf, "", log.Ldate|log.Ltime)
@@ -181,7 +235,7 @@ func TestUpdateTask(t *testing.T) {
 		UserID:      1,
 		Title:       "Test title",
 		Description: "Test description",
-		Status:      "Create",
+		Status:      model.StatusTodo,
 	}
 
 	if err := database.Database.Create(&task).Error; err != nil {
@@ -195,10 +249,10 @@ func TestUpdateTask(t *testing.T) {
 		UserID:      1,
 		Title:       "Test mock title",
 		Description: "Test mock description",
-		Status:      "Update",
+		Status:      model.StatusInProgress,
 	}
 	updatedTask.ID = taskID
-	err := worker.UpdateTask(updatedTask, logger)
+	_, err := worker.UpdateTask(updatedTask, logger)
 	if err != nil {
 		t.Errorf("Error in updating task: %v", err)
 	}
@@ -209,7 +263,7 @@ func TestUpdateTask(t *testing.T) {
 func TestGetTask(t *testing.T) {
 	var buf bytes.Buffer
 	logger := log.New(&buf, "", log.Ldate|log.Ltime)
-	database.Connect()
+	connectDatabase(t)
 
 	baseUsername := "mockuser"
 	uniqueUsername := fmt.Sprintf("%s%d", baseUsername, time.Now().UnixNano())
@@ -227,7 +281,7 @@ func TestGetTask(t *testing.T) {
 		UserID:      user.ID,
 		Title:       "Test title",
 		Description: "Test description",
-		Status:      "Read",
+		Status:      model.StatusTodo,
 	}
 
 	if err := database.Database.Create(&task1).Error; err != nil {
@@ -238,7 +292,7 @@ func TestGetTask(t *testing.T) {
 		UserID:      user.ID,
 		Title:       "Test title1",
 		Description: "Test description1",
-		Status:      "Read",
+		Status:      model.StatusTodo,
 	}
 
 	if err := database.Database.Create(&task2).Error; err != nil {
@@ -252,3 +306,373 @@ func TestGetTask(t *testing.T) {
 
 	logger.Printf("Tasks are readed %v.", readedTasks)
 }
+
+func TestTaskStatusTransitions(t *testing.T) {
+	cases := []struct {
+		from, to model.TaskStatus
+		allowed  bool
+	}{
+		{model.StatusTodo, model.StatusInProgress, true},
+		{model.StatusInProgress, model.StatusDone, true},
+		{model.StatusDone, model.StatusBlocked, false},
+		{model.StatusArchived, model.StatusDone, false},
+		{model.StatusArchived, model.StatusTodo, true},
+		{model.StatusTodo, "create", false},
+		{"create", model.StatusTodo, true},
+	}
+	for _, tc := range cases {
+		if got := tc.from.CanTransitionTo(tc.to); got != tc.allowed {
+			t.Errorf("%s -> %s: got %v want %v", tc.from, tc.to, got, tc.allowed)
+		}
+	}
+}
+
+func TestOIDCLogin(t *testing.T) {
+	connectDatabase(t)
+	logger := log.New(&bytes.Buffer{}, "", 0)
+	provider := oidctest.NewServer("konzek", "s3cret")
+	defer provider.Close()
+	provider.User = oidctest.User{Subject: "oidc-test-subject", PreferredUsername: "oidc-test-user"}
+	defer database.Database.Exec("DELETE FROM user_identities WHERE subject = $1", provider.User.Subject)
+	defer database.Database.Exec("DELETE FROM users WHERE id IN (SELECT user_id FROM user_identities WHERE subject = $1)", provider.User.Subject)
+
+	controller.ConfigureOIDC(time.Minute, oidc.NewProvider(oidc.Config{
+		Name:         "test",
+		Issuer:       provider.Issuer(),
+		ClientID:     "konzek",
+		ClientSecret: "s3cret",
+		RedirectURL:  "http://app.example/auth/oidc/test/callback",
+	}, nil))
+	router := gin.New()
+	router.GET("/auth/oidc/:provider/login", controller.OIDCLoginHandler(logger))
+	router.GET("/auth/oidc/:provider/callback", controller.OIDCCallbackHandler(logger))
+
+	login := func() (*http.Cookie, string) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/auth/oidc/test/login", nil))
+		if rr.Code != http.StatusFound {
+			t.Fatalf("login: status %d: %s", rr.Code, rr.Body)
+		}
+		callback, err := provider.Login(rr.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rr.Result().Cookies()[0], callback.RequestURI()
+	}
+	callback := func(cookie *http.Cookie, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Without the browser's state cookie the callback is refused.
+	if _, target := login(); callback(nil, target).Code != http.StatusUnauthorized {
+		t.Error("callback accepted without the state cookie")
+	}
+
+	cookie, target := login()
+	rr := callback(cookie, target)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("callback: status %d: %s", rr.Code, rr.Body)
+	}
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Data["jwt"] == nil || body.Data["refresh_token"] == nil {
+		t.Errorf("no tokens in %s", rr.Body)
+	}
+	user, err := model.FindUserByIdentity("test", provider.User.Subject)
+	if err != nil || user.Username != "oidc-test-user" {
+		t.Fatalf("user = %+v, err = %v", user, err)
+	}
+
+	// The state works once.
+	if callback(cookie, target).Code != http.StatusUnauthorized {
+		t.Error("state used twice")
+	}
+
+	// Logging in again finds the same user.
+	cookie, target = login()
+	if rr := callback(cookie, target); rr.Code != http.StatusOK {
+		t.Fatalf("second login: status %d: %s", rr.Code, rr.Body)
+	}
+	again, err := model.FindUserByIdentity("test", provider.User.Subject)
+	if err != nil || again.ID != user.ID {
+		t.Errorf("second login made another user: %+v, %v", again, err)
+	}
+}
+
+func TestDeleteUserReassignsTasks(t *testing.T) {
+	connectDatabase(t)
+
+	leaving := model.User{Username: "leaving-user", Password: "Leaving-pass-1"}
+	heir := model.User{Username: "heir-user", Password: "Heir-pass-123"}
+	for _, user := range []*model.User{&leaving, &heir} {
+		if err := database.Database.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+		defer database.Database.Unscoped().Delete(user)
+	}
+	task := model.Task{UserID: leaving.ID, Title: "Handed over", Description: "-", Status: model.StatusTodo}
+	if err := database.Database.Create(&task).Error; err != nil {
+		t.Fatal(err)
+	}
+	defer database.Database.Unscoped().Delete(&task)
+
+	policy := model.DeletionPolicy{Mode: model.DeleteTasksReassign, ReassignTo: heir.ID}
+	if err := policy.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if err := model.DeleteUser(leaving.ID, policy); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.FindUserById(leaving.ID); err != model.ErrUserNotFound {
+		t.Errorf("deleted user still found: %v", err)
+	}
+	reassigned, err := model.FindTask(task.ID)
+	if err != nil || reassigned.UserID != heir.ID {
+		t.Errorf("task = %+v, err = %v; want it owned by %d", reassigned, err, heir.ID)
+	}
+
+	if err := model.DeleteUser(heir.ID, policy); !errors.Is(err, model.ErrDeleteHeir) {
+		t.Errorf("deleting the heir: got %v, want ErrDeleteHeir", err)
+	}
+	gone := model.DeletionPolicy{Mode: model.DeleteTasksReassign, ReassignTo: leaving.ID}
+	if err := gone.Check(); !errors.Is(err, model.ErrHeirNotFound) {
+		t.Errorf("deleted heir: got %v, want ErrHeirNotFound", err)
+	}
+}
+
+// createTestUsers creates a user for each name, made unique, and deletes
+// them when the test ends.
+func createTestUsers(t *testing.T, names ...string) []model.User {
+	t.Helper()
+	users := make([]model.User, len(names))
+	for i, name := range names {
+		users[i] = model.User{Username: fmt.Sprintf("%s%d", name, time.Now().UnixNano()), Password: "Test-pass-1234"}
+		if err := database.Database.Create(&users[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+		user := users[i]
+		t.Cleanup(func() { database.Database.Unscoped().Delete(&user) })
+	}
+	return users
+}
+
+// createTestTask creates a task of the user in the workspace, 0 for a
+// personal task, and deletes it and its shares when the test ends.
+func createTestTask(t *testing.T, userID, workspaceID uint) model.Task {
+	t.Helper()
+	task := model.Task{UserID: userID, WorkspaceID: workspaceID, Title: "Test title", Description: "Test description", Status: model.StatusTodo}
+	if err := database.Database.Create(&task).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		database.Database.Where("task_id = ?", task.ID).Delete(&model.TaskShare{})
+		database.Database.Unscoped().Delete(&task)
+	})
+	return task
+}
+
+// startTestPool runs the task handlers' worker pool for the test.
+func startTestPool(t *testing.T) {
+	t.Helper()
+	logger := log.New(io.Discard, "", 0)
+	controller.SetLogger(logger)
+	worker.SetLogger(logger)
+	pool, err := worker.NewPool(worker.NewMemoryQueue(10), worker.DefaultPoolConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Start()
+	controller.SetPool(pool)
+	t.Cleanup(func() { pool.Shutdown(context.Background()) })
+}
+
+// serveAs answers req with handlers registered on route, as if user had
+// logged in with the default role.
+func serveAs(user model.User, route string, req *http.Request, handlers ...gin.HandlerFunc) *httptest.ResponseRecorder {
+	authenticate := func(c *gin.Context) {
+		helper.SetPrincipal(c, &helper.Principal{
+			UserID:      user.ID,
+			Username:    user.Username,
+			Roles:       []string{model.RoleUser},
+			Permissions: model.DefaultPermissions(),
+		})
+	}
+	router := gin.New()
+	router.Handle(req.Method, route, append([]gin.HandlerFunc{authenticate}, handlers...)...)
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestWorkspaceTenantChecks(t *testing.T) {
+	connectDatabase(t)
+	startTestPool(t)
+
+	users := createTestUsers(t, "ws-owner", "ws-viewer", "ws-outsider")
+	owner, viewer, outsider := users[0], users[1], users[2]
+	workspace, err := model.CreateWorkspace("Tenant test", owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Database.Delete(&workspace)
+	defer database.Database.Where("workspace_id = ?", workspace.ID).Delete(&model.WorkspaceMembership{})
+	if _, err := model.SetWorkspaceMember(workspace.ID, viewer.ID, model.WorkspaceViewer); err != nil {
+		t.Fatal(err)
+	}
+	personal := createTestTask(t, owner.ID, 0)
+	shared := createTestTask(t, owner.ID, workspace.ID)
+
+	update := func(user model.User, task model.Task, workspaceID uint) int {
+		req := httptest.NewRequest("PUT", fmt.Sprintf("/api/entry/%d", task.ID), strings.NewReader(`{"title": "Renamed", "description": "Changed"}`))
+		if workspaceID != 0 {
+			req.Header.Set(middleware.WorkspaceHeader, fmt.Sprint(workspaceID))
+		}
+		return serveAs(user, "/api/entry/:taskid", req, middleware.ActiveWorkspace(""), controller.UpdateTaskHandler).Code
+	}
+
+	// A workspace task is missing outside its workspace, even for its owner.
+	if code := update(owner, shared, 0); code != http.StatusNotFound {
+		t.Errorf("workspace task updated as a personal one: status %d, want 404", code)
+	}
+	if code := update(owner, personal, workspace.ID); code != http.StatusNotFound {
+		t.Errorf("personal task updated in a workspace: status %d, want 404", code)
+	}
+	if code := update(viewer, shared, workspace.ID); code != http.StatusForbidden {
+		t.Errorf("viewer updated a workspace task: status %d, want 403", code)
+	}
+	if code := update(owner, shared, workspace.ID); code != http.StatusOK {
+		t.Errorf("owner could not update a workspace task: status %d", code)
+	}
+	if code := update(outsider, shared, workspace.ID); code != http.StatusNotFound {
+		t.Errorf("non-member reached a workspace: status %d, want 404", code)
+	}
+
+	tasks, err := model.ReadAllTasksByUserID(owner.ID, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].ID != personal.ID {
+		t.Errorf("personal tasks = %+v, want only task %d", tasks, personal.ID)
+	}
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/api/workspaces/%d/members", workspace.ID), nil)
+	if rr := serveAs(outsider, "/api/workspaces/:id/members", req, middleware.ActiveWorkspace("id"), controller.ListWorkspaceMembersHandler); rr.Code != http.StatusNotFound {
+		t.Errorf("non-member listed members: status %d, want 404", rr.Code)
+	}
+
+	// The only owner can neither leave nor step down.
+	if err := model.RemoveWorkspaceMember(workspace.ID, owner.ID); !errors.Is(err, model.ErrLastWorkspaceOwner) {
+		t.Errorf("last owner left: got %v, want ErrLastWorkspaceOwner", err)
+	}
+	if _, err := model.SetWorkspaceMember(workspace.ID, owner.ID, model.WorkspaceMember); !errors.Is(err, model.ErrLastWorkspaceOwner) {
+		t.Errorf("last owner stepped down: got %v, want ErrLastWorkspaceOwner", err)
+	}
+	if _, err := model.SetWorkspaceMember(workspace.ID, viewer.ID, model.WorkspaceOwner); err != nil {
+		t.Fatal(err)
+	}
+	if err := model.RemoveWorkspaceMember(workspace.ID, owner.ID); err != nil {
+		t.Errorf("owner could not leave with another owner left: %v", err)
+	}
+}
+
+func TestTaskSharing(t *testing.T) {
+	connectDatabase(t)
+	startTestPool(t)
+
+	users := createTestUsers(t, "share-owner", "share-editor", "share-viewer", "share-stranger")
+	owner, editor, viewer, stranger := users[0], users[1], users[2], users[3]
+	task := createTestTask(t, owner.ID, 0)
+	taskPath := fmt.Sprintf("/api/entry/%d", task.ID)
+
+	share := func(user, with model.User, level model.ShareLevel) int {
+		body := fmt.Sprintf(`{"username": %q, "level": %q}`, with.Username, level)
+		req := httptest.NewRequest("POST", taskPath+"/shares", strings.NewReader(body))
+		return serveAs(user, "/api/entry/:taskid/shares", req, controller.ShareTaskHandler).Code
+	}
+	if code := share(owner, editor, model.ShareEditor); code != http.StatusOK {
+		t.Fatalf("sharing as editor: status %d", code)
+	}
+	if code := share(owner, viewer, model.ShareViewer); code != http.StatusOK {
+		t.Fatalf("sharing as viewer: status %d", code)
+	}
+	if code := share(editor, stranger, model.ShareViewer); code != http.StatusForbidden {
+		t.Errorf("editor shared the task on: status %d, want 403", code)
+	}
+
+	tasks, err := model.ReadTasksSharedWith(editor.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].ID != task.ID || !tasks[0].Shared || tasks[0].ShareLevel != model.ShareEditor {
+		t.Errorf("tasks shared with the editor = %+v, want task %d flagged as shared for editing", tasks, task.ID)
+	}
+
+	update := func(user model.User) int {
+		req := httptest.NewRequest("PUT", taskPath, strings.NewReader(`{"title": "Renamed", "description": "Changed"}`))
+		return serveAs(user, "/api/entry/:taskid", req, controller.UpdateTaskHandler).Code
+	}
+	if code := update(editor); code != http.StatusOK {
+		t.Errorf("editor could not update: status %d", code)
+	}
+	if code := update(viewer); code != http.StatusForbidden {
+		t.Errorf("viewer updated: status %d, want 403", code)
+	}
+	req := httptest.NewRequest("DELETE", taskPath, nil)
+	if rr := serveAs(editor, "/api/entry/:taskid", req, controller.DeleteTaskHandler); rr.Code != http.StatusForbidden {
+		t.Errorf("editor deleted the task: status %d, want 403", rr.Code)
+	}
+
+	unshare := func(user, collaborator model.User) int {
+		req := httptest.NewRequest("DELETE", fmt.Sprintf("%s/shares/%d", taskPath, collaborator.ID), nil)
+		return serveAs(user, "/api/entry/:taskid/shares/:id", req, controller.UnshareTaskHandler).Code
+	}
+	if code := unshare(editor, viewer); code != http.StatusForbidden {
+		t.Errorf("editor removed another collaborator: status %d, want 403", code)
+	}
+	if code := unshare(viewer, viewer); code != http.StatusOK {
+		t.Errorf("collaborator could not remove themselves: status %d", code)
+	}
+	if _, err := model.FindShareLevel(task.ID, viewer.ID); !errors.Is(err, model.ErrShareNotFound) {
+		t.Errorf("share still there after leaving: %v", err)
+	}
+}
+
+func TestCreateTaskIgnoresClientID(t *testing.T) {
+	connectDatabase(t)
+	startTestPool(t)
+
+	users := createTestUsers(t, "create-victim", "create-attacker")
+	victim, attacker := users[0], users[1]
+	existing := createTestTask(t, victim.ID, 0)
+	defer database.Database.Unscoped().Where("user_id = ?", attacker.ID).Delete(&model.Task{})
+
+	body := fmt.Sprintf(`{"ID": %d, "title": "Taken over", "description": "-"}`, existing.ID)
+	req := httptest.NewRequest("POST", "/api/entry", strings.NewReader(body))
+	if rr := serveAs(attacker, "/api/entry", req, controller.CreateTaskHandler); rr.Code != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", rr.Code, rr.Body)
+	}
+
+	task, err := model.FindTask(existing.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.UserID != victim.ID || task.Title != existing.Title {
+		t.Errorf("existing task changed to %+v", task)
+	}
+	var created int64
+	database.Database.Model(&model.Task{}).Where("user_id = ?", attacker.ID).Count(&created)
+	if created != 1 {
+		t.Errorf("attacker has %d tasks, want the 1 created", created)
+	}
+}
@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"errors"
+	"konzek_assg/helper"
+	"konzek_assg/model"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetJobHandler(c *gin.Context) {
+	start := time.Now()
+	user, err := helper.CurrentPrincipal(c)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+
+	record, err := model.FindJobRecordForUser(c.Param("id"), user.UserID)
+	if err != nil {
+		status := http.StatusInternalServerError
+		message := "internal server error"
+		if errors.Is(err, model.ErrJobNotFound) {
+			status = http.StatusNotFound
+			message = err.Error()
+		} else {
+			logger.Println("Error reading job:", err)
+		}
+		errorResponse := model.ErrorResponse{
+			StatusCode: status,
+			Message:    message,
+		}
+		c.JSON(status, errorResponse)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Job queried successfully",
+		Data:       record,
+	}
+	c.JSON(http.StatusOK, successResponse)
+	observeRequestDuration(c, start)
+}
@@ -0,0 +1,18 @@
+package controller
+
+import (
+	"konzek_assg/helper"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKSHandler publishes the token verification keys. The body is a plain
+// JSON Web Key Set, as JWKS clients expect, rather than a SuccessResponse.
+func JWKSHandler(c *gin.Context) {
+	start := time.Now()
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, helper.JWKS())
+	observeRequestDuration(c, start)
+}
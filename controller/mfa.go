@@ -0,0 +1,304 @@
+package controller
+
+import (
+	"errors"
+	"konzek_assg/helper"
+	"konzek_assg/lockout"
+	"konzek_assg/model"
+	"konzek_assg/totp"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func respondWithMFAError(context *gin.Context, err error, logger *log.Logger) {
+	status := http.StatusInternalServerError
+	message := "internal server error"
+	switch {
+	case errors.Is(err, model.ErrMFAAlreadyEnabled):
+		status = http.StatusConflict
+		message = err.Error()
+	case errors.Is(err, model.ErrMFANotEnabled), errors.Is(err, model.ErrMFANotEnrolled):
+		status = http.StatusNotFound
+		message = err.Error()
+	case errors.Is(err, helper.ErrInvalidMFACode):
+		status = http.StatusUnauthorized
+		message = err.Error()
+	default:
+		logger.Println("Error handling two-factor authentication: ", err)
+	}
+	errorResponse := model.ErrorResponse{
+		StatusCode: status,
+		Message:    message,
+	}
+	context.JSON(status, errorResponse)
+}
+
+func EnrollMFAHandler(logger *log.Logger) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		EnrollMFA(context, logger)
+	}
+}
+
+// EnrollMFA creates a TOTP secret for the caller. It only takes effect once
+// confirmed with a code from the authenticator app, see ConfirmMFA.
+func EnrollMFA(context *gin.Context, logger *log.Logger) {
+	principal, err := helper.CurrentPrincipal(context)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusUnauthorized,
+			Message:    "Authentication required",
+		}
+		context.JSON(http.StatusUnauthorized, errorResponse)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err == nil {
+		err = model.StartMFAEnrollment(principal.UserID, secret)
+	}
+	if err != nil {
+		respondWithMFAError(context, err, logger)
+		return
+	}
+
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Add the secret to your authenticator app, then confirm with a code.",
+		Data: gin.H{
+			"secret":      secret,
+			"otpauth_uri": totp.URI(helper.Issuer(), principal.Username, secret),
+		},
+	}
+	context.JSON(http.StatusOK, successResponse)
+}
+
+func ConfirmMFAHandler(logger *log.Logger) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		ConfirmMFA(context, logger)
+	}
+}
+
+// ConfirmMFA enables two-factor authentication with a code proving the
+// authenticator app has the secret, and returns the recovery codes. They are
+// shown only this once.
+func ConfirmMFA(context *gin.Context, logger *log.Logger) {
+	principal, err := helper.CurrentPrincipal(context)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusUnauthorized,
+			Message:    "Authentication required",
+		}
+		context.JSON(http.StatusUnauthorized, errorResponse)
+		return
+	}
+	var input model.MFACodeInput
+	if err := context.ShouldBindJSON(&input); err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		context.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+
+	enrollment, err := model.FindMFAEnrollment(principal.UserID)
+	if err != nil {
+		respondWithMFAError(context, err, logger)
+		return
+	}
+	step, ok := totp.Validate(enrollment.Secret, input.Code, time.Now())
+	if !ok {
+		respondWithMFAError(context, helper.ErrInvalidMFACode, logger)
+		return
+	}
+	codes, hashes, err := helper.NewRecoveryCodes()
+	if err == nil {
+		err = model.ConfirmMFA(principal.UserID, step, hashes)
+	}
+	if err != nil {
+		respondWithMFAError(context, err, logger)
+		return
+	}
+
+	logger.Printf("User %s enabled two-factor authentication.\n", principal.Username)
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Two-factor authentication enabled. Store the recovery codes safely, they are not shown again.",
+		Data:       gin.H{"recovery_codes": codes},
+	}
+	context.JSON(http.StatusOK, successResponse)
+}
+
+func DisableMFAHandler(logger *log.Logger) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		DisableMFA(context, logger)
+	}
+}
+
+// DisableMFA turns two-factor authentication off; it takes the password and
+// a current or recovery code. Wrong ones count towards the same lockout as
+// wrong passwords at login, so a stolen access token cannot be used to guess
+// them.
+func DisableMFA(context *gin.Context, logger *log.Logger) {
+	principal, err := helper.CurrentPrincipal(context)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusUnauthorized,
+			Message:    "Authentication required",
+		}
+		context.JSON(http.StatusUnauthorized, errorResponse)
+		return
+	}
+	var input model.DisableMFAInput
+	if err := context.ShouldBindJSON(&input); err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		context.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+
+	user, err := model.FindUserById(principal.UserID)
+	if err != nil {
+		respondWithUserError(context, err)
+		return
+	}
+	attempt := model.LoginAttempt{
+		Username:  user.Username,
+		UserID:    user.ID,
+		IP:        context.ClientIP(),
+		UserAgent: context.Request.UserAgent(),
+	}
+	reservation, wait, err := lockout.Reserve(attempt)
+	if err != nil {
+		respondWithMFAError(context, err, logger)
+		return
+	}
+	if wait > 0 {
+		attempt.Reason = model.LoginLockedOut
+		recordFailedLogin(attempt, logger)
+		context.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusTooManyRequests,
+			Message:    "Too many failed login attempts, try again later",
+		}
+		context.JSON(http.StatusTooManyRequests, errorResponse)
+		return
+	}
+	if err := user.ValidatePassword(input.Password); err != nil {
+		attempt.Reason = model.LoginWrongPassword
+		recordFailedLogin(attempt, logger)
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusUnauthorized,
+			Message:    "Password is incorrect",
+		}
+		context.JSON(http.StatusUnauthorized, errorResponse)
+		return
+	}
+	if err := helper.VerifySecondFactor(user.ID, input.Code); err != nil {
+		if errors.Is(err, helper.ErrInvalidMFACode) {
+			attempt.Reason = model.LoginWrongMFACode
+			recordFailedLogin(attempt, logger)
+		} else {
+			releaseAttempt(reservation, logger)
+		}
+		respondWithMFAError(context, err, logger)
+		return
+	}
+	releaseAttempt(reservation, logger)
+	if err := model.DisableMFA(user.ID); err != nil {
+		respondWithMFAError(context, err, logger)
+		return
+	}
+
+	logger.Printf("User %s disabled two-factor authentication.\n", user.Username)
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Two-factor authentication disabled.",
+	}
+	context.JSON(http.StatusOK, successResponse)
+}
+
+func VerifyMFAHandler(logger *log.Logger) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		VerifyMFA(context, logger)
+	}
+}
+
+// VerifyMFA is the second step of a two-factor login: it exchanges the mfa
+// token from Login and a code for the access and refresh tokens. Wrong codes
+// count towards the same lockout as wrong passwords.
+func VerifyMFA(context *gin.Context, logger *log.Logger) {
+	var input model.MFALoginInput
+	if err := context.ShouldBindJSON(&input); err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		context.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+
+	claims, err := helper.ParseMFAToken(context.Request.Context(), input.MFAToken)
+	var user model.User
+	if err == nil {
+		userID, _ := claims.UserID()
+		user, err = model.FindUserWithRoles(userID)
+	}
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusUnauthorized,
+			Message:    "Invalid or expired mfa token, log in again",
+		}
+		context.JSON(http.StatusUnauthorized, errorResponse)
+		return
+	}
+
+	attempt := model.LoginAttempt{
+		Username:  user.Username,
+		UserID:    user.ID,
+		IP:        context.ClientIP(),
+		UserAgent: context.Request.UserAgent(),
+	}
+	reservation, wait, err := lockout.Reserve(attempt)
+	if err != nil {
+		respondWithMFAError(context, err, logger)
+		return
+	}
+	if wait > 0 {
+		attempt.Reason = model.LoginLockedOut
+		recordFailedLogin(attempt, logger)
+		context.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusTooManyRequests,
+			Message:    "Too many failed login attempts, try again later",
+		}
+		context.JSON(http.StatusTooManyRequests, errorResponse)
+		return
+	}
+
+	if err := helper.VerifySecondFactor(user.ID, input.Code); err != nil {
+		if errors.Is(err, helper.ErrInvalidMFACode) {
+			attempt.Reason = model.LoginWrongMFACode
+			recordFailedLogin(attempt, logger)
+		} else {
+			releaseAttempt(reservation, logger)
+		}
+		respondWithMFAError(context, err, logger)
+		return
+	}
+	releaseAttempt(reservation, logger)
+	// The mfa token works once.
+	if err := helper.RevokeToken(context.Request.Context(), claims); err != nil {
+		respondWithMFAError(context, err, logger)
+		return
+	}
+
+	completeLogin(context, user, logger)
+}
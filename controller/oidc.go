@@ -0,0 +1,205 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"errors"
+	"konzek_assg/helper"
+	"konzek_assg/model"
+	"konzek_assg/oidc"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// oidcStateCookie binds a login started in a browser to that browser, so a
+// callback URL made for someone else cannot log them in or link to them.
+const oidcStateCookie = "oidc_state"
+
+var (
+	oidcProviders = map[string]*oidc.Provider{}
+	oidcStateTTL  = 10 * time.Minute
+)
+
+// ConfigureOIDC sets the providers users can log in with and how long a
+// login at a provider may take.
+func ConfigureOIDC(stateTTL time.Duration, providers ...*oidc.Provider) {
+	oidcStateTTL = stateTTL
+	oidcProviders = make(map[string]*oidc.Provider, len(providers))
+	for _, provider := range providers {
+		oidcProviders[provider.Name()] = provider
+	}
+}
+
+func findOIDCProvider(context *gin.Context) (*oidc.Provider, bool) {
+	provider, ok := oidcProviders[context.Param("provider")]
+	if !ok {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusNotFound,
+			Message:    "Unknown identity provider",
+		}
+		context.JSON(http.StatusNotFound, errorResponse)
+	}
+	return provider, ok
+}
+
+func respondWithOIDCError(context *gin.Context, err error, logger *log.Logger) {
+	status := http.StatusInternalServerError
+	message := "internal server error"
+	switch {
+	case errors.Is(err, model.ErrOIDCStateInvalid):
+		status = http.StatusUnauthorized
+		message = "Invalid or expired login, start again"
+	case errors.Is(err, oidc.ErrInvalidIDToken), errors.Is(err, oidc.ErrNonceMismatch):
+		logger.Println("Rejected id token: ", err)
+		status = http.StatusUnauthorized
+		message = "The identity provider's answer could not be verified"
+	case errors.Is(err, model.ErrIdentityLinked):
+		status = http.StatusConflict
+		message = err.Error()
+	default:
+		logger.Println("Error with external login: ", err)
+	}
+	errorResponse := model.ErrorResponse{
+		StatusCode: status,
+		Message:    message,
+	}
+	context.JSON(status, errorResponse)
+}
+
+// startOIDCLogin stores a login with the provider and sets the cookie the
+// callback checks. It answers with an error itself and returns "" then.
+func startOIDCLogin(context *gin.Context, provider *oidc.Provider, linkUserID uint, logger *log.Logger) string {
+	authURL, state, err := helper.StartOIDCLogin(context.Request.Context(), provider, linkUserID, oidcStateTTL)
+	if err != nil {
+		respondWithOIDCError(context, err, logger)
+		return ""
+	}
+	secure := context.Request.TLS != nil || strings.EqualFold(context.GetHeader("X-Forwarded-Proto"), "https")
+	context.SetSameSite(http.SameSiteLaxMode)
+	context.SetCookie(oidcStateCookie, state, int(oidcStateTTL.Seconds()), "/auth/oidc", "", secure, true)
+	return authURL
+}
+
+func OIDCLoginHandler(logger *log.Logger) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		OIDCLogin(context, logger)
+	}
+}
+
+// OIDCLogin sends the browser to the provider to log in.
+func OIDCLogin(context *gin.Context, logger *log.Logger) {
+	provider, ok := findOIDCProvider(context)
+	if !ok {
+		return
+	}
+	if authURL := startOIDCLogin(context, provider, 0, logger); authURL != "" {
+		context.Redirect(http.StatusFound, authURL)
+	}
+}
+
+func OIDCLinkHandler(logger *log.Logger) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		OIDCLink(context, logger)
+	}
+}
+
+// OIDCLink starts linking the caller to an identity at the provider. The
+// caller opens the returned URL in the same browser, and the callback links
+// whoever logs in there.
+func OIDCLink(context *gin.Context, logger *log.Logger) {
+	principal, err := helper.CurrentPrincipal(context)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusUnauthorized,
+			Message:    "Authentication required",
+		}
+		context.JSON(http.StatusUnauthorized, errorResponse)
+		return
+	}
+	provider, ok := findOIDCProvider(context)
+	if !ok {
+		return
+	}
+	authURL := startOIDCLogin(context, provider, principal.UserID, logger)
+	if authURL == "" {
+		return
+	}
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Open the authorization_url to link your account.",
+		Data:       gin.H{"authorization_url": authURL},
+	}
+	context.JSON(http.StatusOK, successResponse)
+}
+
+func OIDCCallbackHandler(logger *log.Logger) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		OIDCCallback(context, logger)
+	}
+}
+
+// OIDCCallback is where the provider sends the browser back. It either
+// links the identity, or logs its user in like Login, creating the user on
+// their first login.
+func OIDCCallback(context *gin.Context, logger *log.Logger) {
+	provider, ok := findOIDCProvider(context)
+	if !ok {
+		return
+	}
+	if providerError := context.Query("error"); providerError != "" {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusUnauthorized,
+			Message:    "Login at the identity provider failed: " + providerError,
+		}
+		context.JSON(http.StatusUnauthorized, errorResponse)
+		return
+	}
+
+	state := context.Query("state")
+	cookie, _ := context.Cookie(oidcStateCookie)
+	context.SetCookie(oidcStateCookie, "", -1, "/auth/oidc", "", false, true)
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookie)) != 1 {
+		respondWithOIDCError(context, model.ErrOIDCStateInvalid, logger)
+		return
+	}
+
+	token, login, err := helper.FinishOIDCLogin(context.Request.Context(), provider, state, context.Query("code"))
+	if err != nil {
+		respondWithOIDCError(context, err, logger)
+		return
+	}
+
+	if login.LinkUserID != 0 {
+		identity := model.UserIdentity{
+			UserID:   login.LinkUserID,
+			Provider: provider.Name(),
+			Subject:  token.Subject,
+			Email:    token.Email,
+		}
+		if err := model.LinkIdentity(&identity); err != nil {
+			respondWithOIDCError(context, err, logger)
+			return
+		}
+		logger.Printf("User %d linked their %s identity.\n", login.LinkUserID, provider.Name())
+		successResponse := model.SuccessResponse{
+			StatusCode: http.StatusOK,
+			Message:    "Account linked successfully",
+			Data:       identity,
+		}
+		context.JSON(http.StatusOK, successResponse)
+		return
+	}
+
+	user, created, err := helper.OIDCUser(provider.Name(), token)
+	if err != nil {
+		respondWithOIDCError(context, err, logger)
+		return
+	}
+	if created {
+		logger.Printf("User %s created on first login with %s.\n", user.Username, provider.Name())
+	}
+	continueLogin(context, user, logger)
+}
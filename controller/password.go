@@ -0,0 +1,294 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"konzek_assg/config"
+	"konzek_assg/helper"
+	"konzek_assg/lockout"
+	"konzek_assg/model"
+	"konzek_assg/notify"
+	"konzek_assg/password"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	notifier         notify.Notifier = notify.NewLogNotifier(log.Default())
+	passwordResetTTL                 = config.Default().Password.ResetTokenTTL
+)
+
+// ConfigurePasswordReset sets how long reset tokens last and how they reach
+// the user.
+func ConfigurePasswordReset(ttl time.Duration, n notify.Notifier) {
+	passwordResetTTL = ttl
+	notifier = n
+}
+
+// respondWithPolicyError answers 422 listing what is wrong with a new
+// password, or 500 for any other error.
+func respondWithPolicyError(context *gin.Context, err error, logger *log.Logger) {
+	status := http.StatusUnprocessableEntity
+	message := err.Error()
+	if !errors.Is(err, password.ErrPolicy) {
+		logger.Println("Error checking password: ", err)
+		status = http.StatusInternalServerError
+		message = "internal server error"
+	}
+	errorResponse := model.ErrorResponse{
+		StatusCode: status,
+		Message:    message,
+	}
+	context.JSON(status, errorResponse)
+}
+
+func ChangePasswordHandler(logger *log.Logger) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		ChangePassword(context, logger)
+	}
+}
+
+// ChangePassword sets a new password for the caller, who has to give the
+// current one, and logs every session out, this one included. Wrong current
+// passwords count towards the same lockout as wrong passwords at login, so a
+// stolen access token cannot be used to guess the password.
+func ChangePassword(context *gin.Context, logger *log.Logger) {
+	principal, err := helper.CurrentPrincipal(context)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusUnauthorized,
+			Message:    "Authentication required",
+		}
+		context.JSON(http.StatusUnauthorized, errorResponse)
+		return
+	}
+	var input model.ChangePasswordInput
+	if err := context.ShouldBindJSON(&input); err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		context.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+
+	user, err := model.FindUserById(principal.UserID)
+	if err != nil {
+		respondWithUserError(context, err)
+		return
+	}
+	attempt := model.LoginAttempt{
+		Username:  user.Username,
+		UserID:    user.ID,
+		IP:        context.ClientIP(),
+		UserAgent: context.Request.UserAgent(),
+	}
+	reservation, wait, err := lockout.Reserve(attempt)
+	if err != nil {
+		logger.Println("Error checking login attempts: ", err)
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusInternalServerError,
+			Message:    "internal server error",
+		}
+		context.JSON(http.StatusInternalServerError, errorResponse)
+		return
+	}
+	if wait > 0 {
+		attempt.Reason = model.LoginLockedOut
+		recordFailedLogin(attempt, logger)
+		context.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusTooManyRequests,
+			Message:    "Too many failed login attempts, try again later",
+		}
+		context.JSON(http.StatusTooManyRequests, errorResponse)
+		return
+	}
+	if err := user.ValidatePassword(input.CurrentPassword); err != nil {
+		attempt.Reason = model.LoginWrongPassword
+		recordFailedLogin(attempt, logger)
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusUnauthorized,
+			Message:    "Current password is incorrect",
+		}
+		context.JSON(http.StatusUnauthorized, errorResponse)
+		return
+	}
+	releaseAttempt(reservation, logger)
+	if input.NewPassword == input.CurrentPassword {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusUnprocessableEntity,
+			Message:    "New password must differ from the current one",
+		}
+		context.JSON(http.StatusUnprocessableEntity, errorResponse)
+		return
+	}
+	if err := password.CheckPolicy(input.NewPassword, user.Username); err != nil {
+		respondWithPolicyError(context, err, logger)
+		return
+	}
+
+	if err := user.ChangePassword(input.NewPassword); err != nil {
+		logger.Println("Error changing password: ", err)
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusInternalServerError,
+			Message:    "internal server error",
+		}
+		context.JSON(http.StatusInternalServerError, errorResponse)
+		return
+	}
+	if err := helper.RevokeAllSessions(context.Request.Context(), user.ID); err != nil {
+		logger.Println("Error revoking sessions after password change: ", err)
+	}
+
+	logger.Printf("User %s changed their password.\n", user.Username)
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Password changed successfully, please log in again.",
+	}
+	context.JSON(http.StatusOK, successResponse)
+}
+
+func RequestPasswordResetHandler(logger *log.Logger) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		RequestPasswordReset(context, logger)
+	}
+}
+
+// RequestPasswordReset sends a reset token to the user through the
+// notifier. The answer is the same whether the user exists or not, and so
+// is the time it takes: the user is looked up and the token sent after
+// answering. Requests are limited per username and per client IP.
+func RequestPasswordReset(context *gin.Context, logger *log.Logger) {
+	var input model.PasswordResetRequestInput
+	if err := context.ShouldBindJSON(&input); err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		context.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+
+	wait, err := lockout.ThrottleReset(input.Username, context.ClientIP())
+	if err != nil {
+		logger.Println("Error checking password reset requests: ", err)
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusInternalServerError,
+			Message:    "internal server error",
+		}
+		context.JSON(http.StatusInternalServerError, errorResponse)
+		return
+	}
+	if wait > 0 {
+		context.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusTooManyRequests,
+			Message:    "Too many password reset requests, try again later",
+		}
+		context.JSON(http.StatusTooManyRequests, errorResponse)
+		return
+	}
+
+	go sendPasswordReset(input.Username, logger)
+
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusAccepted,
+		Message:    "If the account exists, a password reset token has been sent.",
+	}
+	context.JSON(http.StatusAccepted, successResponse)
+}
+
+// sendPasswordReset runs after the request was answered, so it must not use
+// the request's context.
+func sendPasswordReset(username string, logger *log.Logger) {
+	user, err := model.FindUserByUsername(username)
+	if err != nil {
+		logger.Println("Error finding user for password reset: ", err)
+		return
+	}
+	if user.ID == 0 {
+		logger.Printf("Password reset requested for unknown user %q.\n", username)
+		return
+	}
+	token, expiresAt, err := helper.IssuePasswordResetToken(user.ID, passwordResetTTL)
+	if err != nil {
+		logger.Println("Error issuing password reset token: ", err)
+		return
+	}
+	reset := notify.PasswordReset{
+		UserID:    user.ID,
+		Username:  user.Username,
+		Token:     token,
+		ExpiresAt: expiresAt,
+	}
+	if err := notifier.PasswordReset(context.Background(), reset); err != nil {
+		logger.Println("Error sending password reset token: ", err)
+	}
+}
+
+func ResetPasswordHandler(logger *log.Logger) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		ResetPassword(context, logger)
+	}
+}
+
+// ResetPassword sets a new password with a reset token. The token works
+// once, and every session of the user is logged out.
+func ResetPassword(context *gin.Context, logger *log.Logger) {
+	var input model.PasswordResetInput
+	if err := context.ShouldBindJSON(&input); err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		context.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+
+	// Check the policy before spending the token, so a refused password
+	// can be retried with the same token.
+	user, err := helper.PasswordResetUser(input.Token)
+	if err == nil {
+		err = password.CheckPolicy(input.NewPassword, user.Username)
+		if err != nil {
+			respondWithPolicyError(context, err, logger)
+			return
+		}
+		user, err = helper.ResetPassword(input.Token, input.NewPassword)
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		message := "internal server error"
+		if errors.Is(err, model.ErrResetTokenInvalid) {
+			status = http.StatusUnauthorized
+			message = err.Error()
+		} else {
+			logger.Println("Error resetting password: ", err)
+		}
+		errorResponse := model.ErrorResponse{
+			StatusCode: status,
+			Message:    message,
+		}
+		context.JSON(status, errorResponse)
+		return
+	}
+	if err := helper.RevokeAllSessions(context.Request.Context(), user.ID); err != nil {
+		logger.Println("Error revoking sessions after password reset: ", err)
+	}
+	if err := lockout.Succeed(user.ID); err != nil {
+		logger.Println("Error resetting failed login attempts: ", err)
+	}
+
+	logger.Printf("Password of user %s reset.\n", user.Username)
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Password reset successfully, please log in.",
+	}
+	context.JSON(http.StatusOK, successResponse)
+}
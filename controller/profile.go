@@ -0,0 +1,246 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"konzek_assg/helper"
+	"konzek_assg/lockout"
+	"konzek_assg/model"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ChangeUsernameInput struct {
+	Username string `json:"username" binding:"required"`
+}
+
+// DeleteAccountInput confirms deleting one's own account with the password
+// or, for users who never set one, a two-factor code.
+type DeleteAccountInput struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+var deletionPolicy = model.DeletionPolicy{Mode: model.DeleteTasksCascade}
+
+// ConfigureAccountDeletion sets what happens to the tasks of deleted users.
+func ConfigureAccountDeletion(policy model.DeletionPolicy) {
+	deletionPolicy = policy
+}
+
+func currentUserID(c *gin.Context) (uint, bool) {
+	principal, err := helper.CurrentPrincipal(c)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusUnauthorized,
+			Message:    "Authentication required",
+		}
+		c.JSON(http.StatusUnauthorized, errorResponse)
+		return 0, false
+	}
+	return principal.UserID, true
+}
+
+func GetProfileHandler(c *gin.Context) {
+	start := time.Now()
+	id, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+
+	user, err := model.FindUserWithRoles(id)
+	if err != nil {
+		respondWithUserError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Profile queried successfully",
+		Data:       user,
+	}
+	c.JSON(http.StatusOK, successResponse)
+	observeRequestDuration(c, start)
+}
+
+// UpdateProfileHandler changes the fields of the caller's profile that are
+// in the request.
+func UpdateProfileHandler(c *gin.Context) {
+	start := time.Now()
+	id, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var input model.ProfileInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+	if err := input.Validate(); err != nil {
+		respondWithUserError(c, err)
+		return
+	}
+
+	user, err := model.UpdateProfile(id, input)
+	if err != nil {
+		respondWithUserError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Profile updated successfully",
+		Data:       user,
+	}
+	c.JSON(http.StatusOK, successResponse)
+	observeRequestDuration(c, start)
+}
+
+// ChangeUsernameHandler renames the caller. Tokens identify users by ID, so
+// the caller's sessions stay valid under the new name, and so does the login
+// lockout: failed logins count against the user ID, so renaming does not
+// clear them and whoever takes the old name does not inherit them.
+func ChangeUsernameHandler(c *gin.Context) {
+	start := time.Now()
+	id, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var input ChangeUsernameInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+
+	if err := model.ChangeUsername(id, input.Username); err != nil {
+		respondWithUserError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	logger.Printf("User %d changed their username to %s.\n", id, input.Username)
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Username changed successfully",
+		Data:       input.Username,
+	}
+	c.JSON(http.StatusOK, successResponse)
+	observeRequestDuration(c, start)
+}
+
+// DeleteAccountHandler deletes the caller's own account, like
+// DeleteUserHandler, once they confirm it with their password or a fresh
+// two-factor code. Users created by an OIDC login have a password nobody
+// knows, so they confirm with a code or set a password with a reset first.
+// Wrong confirmations count towards the login lockout.
+func DeleteAccountHandler(c *gin.Context) {
+	start := time.Now()
+	id, ok := currentUserID(c)
+	if !ok {
+		return
+	}
+	var input DeleteAccountInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+	if input.Password == "" && input.Code == "" {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    "password or code is required",
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+
+	user, err := model.FindUserById(id)
+	if err != nil {
+		respondWithUserError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+	attempt := model.LoginAttempt{
+		Username:  user.Username,
+		UserID:    user.ID,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+	reservation, wait, err := lockout.Reserve(attempt)
+	if err != nil {
+		respondWithUserError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+	if wait > 0 {
+		attempt.Reason = model.LoginLockedOut
+		recordFailedLogin(attempt, logger)
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusTooManyRequests,
+			Message:    "Too many failed login attempts, try again later",
+		}
+		c.JSON(http.StatusTooManyRequests, errorResponse)
+		observeRequestDuration(c, start)
+		return
+	}
+	if input.Password != "" {
+		if err := user.ValidatePassword(input.Password); err != nil {
+			attempt.Reason = model.LoginWrongPassword
+			recordFailedLogin(attempt, logger)
+			errorResponse := model.ErrorResponse{
+				StatusCode: http.StatusUnauthorized,
+				Message:    "Password is incorrect",
+			}
+			c.JSON(http.StatusUnauthorized, errorResponse)
+			observeRequestDuration(c, start)
+			return
+		}
+	} else if err := helper.VerifySecondFactor(user.ID, input.Code); err != nil {
+		if errors.Is(err, helper.ErrInvalidMFACode) {
+			attempt.Reason = model.LoginWrongMFACode
+			recordFailedLogin(attempt, logger)
+		} else {
+			releaseAttempt(reservation, logger)
+		}
+		respondWithMFAError(c, err, logger)
+		observeRequestDuration(c, start)
+		return
+	}
+	releaseAttempt(reservation, logger)
+	if err := model.DeleteUser(id, deletionPolicy); err != nil {
+		respondWithUserError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), jobTimeout)
+	defer cancel()
+	if err := helper.RevokeAllSessions(ctx, id); err != nil {
+		logger.Printf("Error revoking sessions of deleted user %d: %v\n", id, err)
+	}
+
+	logger.Printf("User %d deleted their account.\n", id)
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Account deleted successfully",
+	}
+	c.JSON(http.StatusOK, successResponse)
+	observeRequestDuration(c, start)
+}
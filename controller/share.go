@@ -0,0 +1,217 @@
+package controller
+
+import (
+	"errors"
+	"konzek_assg/helper"
+	"konzek_assg/model"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ShareTaskInput struct {
+	Username string           `json:"username" binding:"required"`
+	Level    model.ShareLevel `json:"level" binding:"required"`
+}
+
+func respondWithShareError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	message := "internal server error"
+	switch {
+	case errors.Is(err, model.ErrTaskNotFound), errors.Is(err, model.ErrShareNotFound), errors.Is(err, model.ErrUserNotFound):
+		status = http.StatusNotFound
+		message = err.Error()
+	case errors.Is(err, model.ErrInvalidShareLevel):
+		status = http.StatusUnprocessableEntity
+		message = err.Error()
+	default:
+		logger.Println("Error handling task share:", err)
+	}
+	errorResponse := model.ErrorResponse{
+		StatusCode: status,
+		Message:    message,
+	}
+	c.JSON(status, errorResponse)
+}
+
+// ownedTask loads the task in the path and checks that it is a personal task
+// of the caller. It writes the error response itself and reports whether to
+// continue.
+func ownedTask(c *gin.Context, principal *helper.Principal) (model.Task, bool) {
+	taskID, err := strconv.ParseUint(c.Param("taskid"), 10, 64)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Invalid task ID",
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return model.Task{}, false
+	}
+	task, err := model.FindTask(uint(taskID))
+	if err != nil {
+		respondWithShareError(c, err)
+		return model.Task{}, false
+	}
+	if task.WorkspaceID != 0 {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusUnprocessableEntity,
+			Message:    "workspace tasks are shared through workspace membership",
+		}
+		c.JSON(http.StatusUnprocessableEntity, errorResponse)
+		return model.Task{}, false
+	}
+	if task.UserID != principal.UserID {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusForbidden,
+			Message:    "only the owner can manage who a task is shared with",
+		}
+		c.JSON(http.StatusForbidden, errorResponse)
+		return model.Task{}, false
+	}
+	return task, true
+}
+
+func ShareTaskHandler(c *gin.Context) {
+	start := time.Now()
+	principal, err := helper.CurrentPrincipal(c)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+	var input ShareTaskInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+	task, ok := ownedTask(c, principal)
+	if !ok {
+		observeRequestDuration(c, start)
+		return
+	}
+
+	collaborator, err := model.FindUserByUsername(input.Username)
+	if err == nil && collaborator.ID == 0 {
+		err = model.ErrUserNotFound
+	}
+	if err != nil {
+		respondWithShareError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+	if collaborator.ID == task.UserID {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusUnprocessableEntity,
+			Message:    "a task cannot be shared with its owner",
+		}
+		c.JSON(http.StatusUnprocessableEntity, errorResponse)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	share, err := model.ShareTask(task.ID, collaborator.ID, input.Level)
+	if err != nil {
+		respondWithShareError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	logger.Printf("Task %d shared with user %d as %s.\n", task.ID, collaborator.ID, input.Level)
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Task shared successfully",
+		Data:       share,
+	}
+	c.JSON(http.StatusOK, successResponse)
+	observeRequestDuration(c, start)
+}
+
+func ListTaskSharesHandler(c *gin.Context) {
+	start := time.Now()
+	principal, err := helper.CurrentPrincipal(c)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+	task, ok := ownedTask(c, principal)
+	if !ok {
+		observeRequestDuration(c, start)
+		return
+	}
+
+	shares, err := model.ListTaskShares(task.ID)
+	if err != nil {
+		respondWithShareError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Task shares queried successfully",
+		Data:       shares,
+	}
+	c.JSON(http.StatusOK, successResponse)
+	observeRequestDuration(c, start)
+}
+
+// UnshareTaskHandler stops sharing a task with a user. The owner can remove
+// anyone; a collaborator can only remove themselves.
+func UnshareTaskHandler(c *gin.Context) {
+	start := time.Now()
+	principal, err := helper.CurrentPrincipal(c)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+	taskID, err := strconv.ParseUint(c.Param("taskid"), 10, 64)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Invalid task ID",
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+	collaboratorID, ok := userID(c)
+	if !ok {
+		return
+	}
+
+	if collaboratorID != principal.UserID {
+		if _, ok := ownedTask(c, principal); !ok {
+			observeRequestDuration(c, start)
+			return
+		}
+	}
+	if err := model.UnshareTask(uint(taskID), collaboratorID); err != nil {
+		respondWithShareError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	logger.Printf("Task %d no longer shared with user %d.\n", taskID, collaboratorID)
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Task unshared successfully",
+	}
+	c.JSON(http.StatusOK, successResponse)
+	observeRequestDuration(c, start)
+}
@@ -1,13 +1,17 @@
 package controller
 
 import (
+	"context"
+	"errors"
+	"konzek_assg/config"
 	"konzek_assg/helper"
 	"konzek_assg/model"
 	"konzek_assg/worker"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
-	"sync"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -16,10 +20,11 @@ import (
 )
 
 var (
-	logger         *log.Logger
-	taskCh         = make(chan model.Task)
-	taskResultLock sync.Mutex
-	taskResults    = make(map[uint][]model.Task)
+	logger *log.Logger
+	pool   *worker.Pool
+	// jobTimeout bounds how long a handler waits for the worker pool to
+	// report the outcome of a job.
+	jobTimeout = config.Default().Server.JobTimeout
 )
 
 var (
@@ -32,15 +37,122 @@ var (
 	)
 )
 
-func InitializeController() {
+func Configure(cfg config.Server) {
+	jobTimeout = cfg.JobTimeout
+}
 
-	taskResults = make(map[uint][]model.Task)
+func SetPool(p *worker.Pool) {
+	pool = p
+}
 
-	worker.SetTaskResults(taskResults)
+func newJob(c *gin.Context, op worker.Operation, task model.Task, requesterID uint) worker.Job {
+	return worker.NewJob(op, task, requesterID, c.GetHeader("X-Correlation-ID"))
 }
 
-func SetTaskChannel(tc chan model.Task) {
-	taskCh = tc
+// submitJob sends the job to the worker pool and waits for its outcome. The
+// returned error is either a context error or the error reported by the worker.
+func submitJob(c *gin.Context, job worker.Job) (worker.Result, error) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), jobTimeout)
+	defer cancel()
+
+	result, err := pool.Submit(ctx, job)
+	if err != nil {
+		return result, err
+	}
+	return result, result.Err
+}
+
+// wantsAsync reports whether the client asked not to wait for the worker
+// pool, either with "Prefer: respond-async" or with ?async=true.
+func wantsAsync(c *gin.Context) bool {
+	if async, err := strconv.ParseBool(c.Query("async")); err == nil && async {
+		return true
+	}
+	for _, preference := range strings.Split(c.GetHeader("Prefer"), ",") {
+		if strings.TrimSpace(preference) == "respond-async" {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptJob records the job, queues it and answers 202 with the location of
+// its status resource.
+func acceptJob(c *gin.Context, job worker.Job) {
+	job.Track = true
+	record := model.JobRecord{
+		ID:        job.ID,
+		UserID:    job.RequesterID,
+		Operation: string(job.Operation),
+		TaskID:    job.Payload.ID,
+		Status:    model.JobQueued,
+	}
+	if err := model.CreateJobRecord(&record); err != nil {
+		logger.Println("Error recording job:", err)
+		respondWithJobError(c, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), jobTimeout)
+	defer cancel()
+	if err := pool.Enqueue(ctx, job); err != nil {
+		logger.Println("Error queueing job:", err)
+		if markErr := model.UpdateJobRecord(job.ID, model.JobFailed, 0, err.Error()); markErr != nil {
+			logger.Println("Error marking job as failed:", markErr)
+		}
+		respondWithJobError(c, err)
+		return
+	}
+
+	c.Header("Location", "/api/jobs/"+job.ID)
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusAccepted,
+		Message:    "Task request accepted",
+		Data:       record,
+	}
+	c.JSON(http.StatusAccepted, successResponse)
+}
+
+func statusForJobError(err error) int {
+	switch {
+	case errors.Is(err, worker.ErrValidation):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, worker.ErrTaskNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, worker.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, worker.ErrTooManyJobs):
+		return http.StatusTooManyRequests
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled), errors.Is(err, worker.ErrQueueClosed), errors.Is(err, worker.ErrQueueFull):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func respondWithJobError(c *gin.Context, err error) {
+	status := statusForJobError(err)
+	message := err.Error()
+	switch status {
+	case http.StatusGatewayTimeout:
+		message = "timed out waiting for the worker pool"
+	case http.StatusServiceUnavailable:
+		message = "the worker pool is not accepting requests"
+	case http.StatusInternalServerError:
+		message = "internal server error"
+	}
+	var limitErr *worker.LimitError
+	if errors.As(err, &limitErr) {
+		seconds := int(math.Ceil(limitErr.RetryAfter.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(seconds))
+	}
+	errorResponse := model.ErrorResponse{
+		StatusCode: status,
+		Message:    message,
+	}
+	c.JSON(status, errorResponse)
 }
 
 func observeRequestDuration(c *gin.Context, start time.Time) {
@@ -57,7 +169,6 @@ func SetLogger(l *log.Logger) {
 func CreateTaskHandler(c *gin.Context) {
 	start := time.Now()
 	var task model.Task
-	task.Status = "create"
 	if err := c.BindJSON(&task); err != nil {
 		errorResponse := model.ErrorResponse{
 			StatusCode: http.StatusBadRequest,
@@ -67,7 +178,7 @@ func CreateTaskHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, errorResponse)
 		return
 	}
-	user, err := helper.CurrentUser(c)
+	user, err := helper.CurrentPrincipal(c)
 	if err != nil {
 		logger.Println("Error getting current user:", err)
 		errorResponse := model.ErrorResponse{
@@ -77,20 +188,43 @@ func CreateTaskHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, errorResponse)
 		return
 	}
-	task.UserID = user.ID
-	taskCh <- task
+	// The task is always new; an ID in the body must not reach another task.
+	task.Model = gorm.Model{}
+	task.UserID = user.UserID
+	task.WorkspaceID = user.WorkspaceID
+	job := newJob(c, worker.OperationCreate, task, user.UserID)
+	if wantsAsync(c) {
+		acceptJob(c, job)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	result, err := submitJob(c, job)
+	if err != nil {
+		logger.Println("Error creating task:", err)
+		respondWithJobError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
 
 	successResponse := model.SuccessResponse{
-		StatusCode: http.StatusOK,
-		Message:    "Task received successfully",
+		StatusCode: http.StatusCreated,
+		Message:    "Task created successfully",
+		Data:       result.Task,
 	}
-	c.JSON(http.StatusOK, successResponse)
+	c.JSON(http.StatusCreated, successResponse)
 	observeRequestDuration(c, start)
 }
 
 func DeleteTaskHandler(c *gin.Context) {
+	deleteTask(c, false)
+}
+
+// deleteTask deletes the task in the path; anyOwner allows deleting tasks of
+// other users.
+func deleteTask(c *gin.Context, anyOwner bool) {
 	start := time.Now()
-	user, err := helper.CurrentUser(c)
+	user, err := helper.CurrentPrincipal(c)
 	if err != nil {
 		errorResponse := model.ErrorResponse{
 			StatusCode: http.StatusBadRequest,
@@ -112,15 +246,28 @@ func DeleteTaskHandler(c *gin.Context) {
 	}
 
 	task := model.Task{
-		UserID: user.ID,
-		Model:  gorm.Model{ID: uint(taskID)},
-		Status: "delete",
+		UserID:      user.UserID,
+		WorkspaceID: user.WorkspaceID,
+		Model:       gorm.Model{ID: uint(taskID)},
 	}
 
-	taskCh <- task
+	job := newJob(c, worker.OperationDelete, task, user.UserID)
+	job.AnyOwner = anyOwner
+	if wantsAsync(c) {
+		acceptJob(c, job)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	if _, err := submitJob(c, job); err != nil {
+		logger.Println("Error deleting task:", err)
+		respondWithJobError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
 	successResponse := model.SuccessResponse{
 		StatusCode: http.StatusOK,
-		Message:    "Task deletion request sent to worker pool",
+		Message:    "Task deleted successfully",
 	}
 	c.JSON(http.StatusOK, successResponse)
 
@@ -128,8 +275,14 @@ func DeleteTaskHandler(c *gin.Context) {
 }
 
 func UpdateTaskHandler(c *gin.Context) {
+	updateTask(c, false)
+}
+
+// updateTask updates the task in the path; anyOwner allows updating tasks of
+// other users.
+func updateTask(c *gin.Context, anyOwner bool) {
 	start := time.Now()
-	user, err := helper.CurrentUser(c)
+	user, err := helper.CurrentPrincipal(c)
 	if err != nil {
 		errorResponse := model.ErrorResponse{
 			StatusCode: http.StatusBadRequest,
@@ -158,39 +311,37 @@ func UpdateTaskHandler(c *gin.Context) {
 		return
 	}
 	task.ID = uint(taskID)
-	task.UserID = user.ID
+	task.UserID = user.UserID
+	task.WorkspaceID = user.WorkspaceID
 
-	task.Status = "update"
-	taskCh <- task
+	job := newJob(c, worker.OperationUpdate, task, user.UserID)
+	job.AnyOwner = anyOwner
+	if wantsAsync(c) {
+		acceptJob(c, job)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	result, err := submitJob(c, job)
+	if err != nil {
+		logger.Println("Error updating task:", err)
+		respondWithJobError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
 
 	successResponse := model.SuccessResponse{
 		StatusCode: http.StatusOK,
-		Message:    "Task update request sent to worker pool",
+		Message:    "Task updated successfully",
+		Data:       result.Task,
 	}
 	c.JSON(http.StatusOK, successResponse)
 
 	observeRequestDuration(c, start)
 }
-func waitForTasks(userID uint) {
-	timeout := time.After(5 * time.Second)
-	for {
-		select {
-		case <-time.After(100 * time.Millisecond):
-			taskResultLock.Lock()
-			tasks := taskResults[userID]
-			taskResultLock.Unlock()
-			if len(tasks) > 0 {
-				return
-			}
-		case <-timeout:
-			return
-		}
-	}
-}
-
 func GetTasksHandler(c *gin.Context) {
 	start := time.Now()
-	user, err := helper.CurrentUser(c)
+	user, err := helper.CurrentPrincipal(c)
 	if err != nil {
 		errorResponse := model.ErrorResponse{
 			StatusCode: http.StatusBadRequest,
@@ -200,31 +351,18 @@ func GetTasksHandler(c *gin.Context) {
 		return
 	}
 
-	task := model.Task{
-		UserID: user.ID,
-		Status: "read",
-	}
-
-	taskCh <- task
-
-	waitForTasks(task.UserID)
-
-	taskResultLock.Lock()
-	defer taskResultLock.Unlock()
-	tasks := taskResults[task.UserID]
-	if len(tasks) == 0 {
-		errorResponse := model.ErrorResponse{
-			StatusCode: http.StatusBadRequest,
-			Message:    "No tasks found for the given user ID",
-		}
-		c.JSON(http.StatusNotFound, errorResponse)
+	result, err := submitJob(c, newJob(c, worker.OperationRead, model.Task{UserID: user.UserID, WorkspaceID: user.WorkspaceID}, user.UserID))
+	if err != nil {
+		logger.Println("Error reading tasks:", err)
+		respondWithJobError(c, err)
+		observeRequestDuration(c, start)
 		return
 	}
 
 	successResponse := model.SuccessResponse{
 		StatusCode: http.StatusOK,
 		Message:    "Tasks queried successfully",
-		Data:       tasks,
+		Data:       result.Tasks,
 	}
 	c.JSON(http.StatusOK, successResponse)
 
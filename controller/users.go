@@ -0,0 +1,256 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"konzek_assg/helper"
+	"konzek_assg/model"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SetRolesInput struct {
+	Roles []string `json:"roles" binding:"required"`
+}
+
+func userID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Invalid user ID",
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func respondWithUserError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	message := "internal server error"
+	switch {
+	case errors.Is(err, model.ErrUserNotFound):
+		status = http.StatusNotFound
+		message = err.Error()
+	case errors.Is(err, model.ErrUnknownRole), errors.Is(err, model.ErrInvalidProfile), errors.Is(err, model.ErrInvalidUsername):
+		status = http.StatusUnprocessableEntity
+		message = err.Error()
+	case errors.Is(err, model.ErrUsernameTaken):
+		status = http.StatusConflict
+		message = err.Error()
+	case errors.Is(err, model.ErrDeleteHeir):
+		status = http.StatusConflict
+		message = err.Error() + ", set ACCOUNT_REASSIGN_TO to another user first"
+	case errors.Is(err, model.ErrHeirNotFound):
+		logger.Println("Error handling user:", err)
+		status = http.StatusUnprocessableEntity
+		message = "account deletion is misconfigured: " + err.Error()
+	case errors.Is(err, model.ErrLastWorkspaceOwner):
+		status = http.StatusConflict
+		message = err.Error() + ", hand ownership over before deleting the account"
+	default:
+		logger.Println("Error handling user:", err)
+	}
+	errorResponse := model.ErrorResponse{
+		StatusCode: status,
+		Message:    message,
+	}
+	c.JSON(status, errorResponse)
+}
+
+func ListUsersHandler(c *gin.Context) {
+	start := time.Now()
+	limit, offset := pagination(c)
+
+	users, err := model.ListUsers(limit, offset)
+	if err != nil {
+		respondWithUserError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Users queried successfully",
+		Data:       users,
+	}
+	c.JSON(http.StatusOK, successResponse)
+	observeRequestDuration(c, start)
+}
+
+func GetUserHandler(c *gin.Context) {
+	start := time.Now()
+	id, ok := userID(c)
+	if !ok {
+		return
+	}
+
+	user, err := model.FindUserWithRoles(id)
+	if err != nil {
+		respondWithUserError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "User queried successfully",
+		Data:       user,
+	}
+	c.JSON(http.StatusOK, successResponse)
+	observeRequestDuration(c, start)
+}
+
+// SetUserRolesHandler replaces the roles of a user. Tokens carry the roles
+// they were issued with, so added permissions apply from the user's next
+// login or token refresh. When permissions are taken away every session of
+// the user is revoked, so they cannot keep using them until expiry.
+func SetUserRolesHandler(c *gin.Context) {
+	start := time.Now()
+	id, ok := userID(c)
+	if !ok {
+		return
+	}
+	var input SetRolesInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+
+	before, err := model.FindUserWithRoles(id)
+	if err != nil {
+		respondWithUserError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+	if err := model.SetUserRoles(id, input.Roles); err != nil {
+		respondWithUserError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+	user, err := model.FindUserWithRoles(id)
+	if err != nil {
+		respondWithUserError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+	if lostPermissions(before.PermissionNames(), user.PermissionNames()) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), jobTimeout)
+		defer cancel()
+		if err := helper.RevokeAllSessions(ctx, id); err != nil {
+			respondWithUserError(c, err)
+			observeRequestDuration(c, start)
+			return
+		}
+		logger.Printf("Sessions of user %d revoked after losing permissions.\n", id)
+	}
+
+	logger.Printf("Roles of user %d set to %v.\n", id, input.Roles)
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "User roles updated successfully",
+		Data:       user,
+	}
+	c.JSON(http.StatusOK, successResponse)
+	observeRequestDuration(c, start)
+}
+
+// lostPermissions reports whether any of before is missing from after.
+func lostPermissions(before, after []string) bool {
+	kept := make(map[string]bool, len(after))
+	for _, permission := range after {
+		kept[permission] = true
+	}
+	for _, permission := range before {
+		if !kept[permission] {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteUserHandler deletes a user, handling their tasks as the deployment
+// configured, and revokes all of their tokens.
+func DeleteUserHandler(c *gin.Context) {
+	start := time.Now()
+	id, ok := userID(c)
+	if !ok {
+		return
+	}
+
+	if err := model.DeleteUser(id, deletionPolicy); err != nil {
+		respondWithUserError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), jobTimeout)
+	defer cancel()
+	if err := helper.RevokeAllSessions(ctx, id); err != nil {
+		logger.Printf("Error revoking sessions of deleted user %d: %v\n", id, err)
+	}
+
+	logger.Printf("User %d deleted.\n", id)
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "User deleted successfully",
+	}
+	c.JSON(http.StatusOK, successResponse)
+	observeRequestDuration(c, start)
+}
+
+// ListAllTasksHandler lists the tasks of every user, or of ?user_id= only.
+func ListAllTasksHandler(c *gin.Context) {
+	start := time.Now()
+	limit, offset := pagination(c)
+	var owner uint
+	if raw := c.Query("user_id"); raw != "" {
+		id, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			errorResponse := model.ErrorResponse{
+				StatusCode: http.StatusBadRequest,
+				Message:    "Invalid user ID",
+			}
+			c.JSON(http.StatusBadRequest, errorResponse)
+			return
+		}
+		owner = uint(id)
+	}
+
+	tasks, err := model.ListTasks(owner, limit, offset)
+	if err != nil {
+		logger.Println("Error listing tasks:", err)
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusInternalServerError,
+			Message:    "internal server error",
+		}
+		c.JSON(http.StatusInternalServerError, errorResponse)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Tasks queried successfully",
+		Data:       tasks,
+	}
+	c.JSON(http.StatusOK, successResponse)
+	observeRequestDuration(c, start)
+}
+
+// AdminUpdateTaskHandler updates a task of any user.
+func AdminUpdateTaskHandler(c *gin.Context) {
+	updateTask(c, true)
+}
+
+// AdminDeleteTaskHandler deletes a task of any user.
+func AdminDeleteTaskHandler(c *gin.Context) {
+	deleteTask(c, true)
+}
@@ -0,0 +1,246 @@
+package controller
+
+import (
+	"errors"
+	"konzek_assg/helper"
+	"konzek_assg/model"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CreateWorkspaceInput struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type WorkspaceMemberInput struct {
+	Username string              `json:"username" binding:"required"`
+	Role     model.WorkspaceRole `json:"role" binding:"required"`
+}
+
+func respondWithWorkspaceError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	message := "internal server error"
+	switch {
+	case errors.Is(err, model.ErrWorkspaceNotFound), errors.Is(err, model.ErrNotWorkspaceMember), errors.Is(err, model.ErrUserNotFound):
+		status = http.StatusNotFound
+		message = err.Error()
+	case errors.Is(err, model.ErrInvalidWorkspaceRole), errors.Is(err, model.ErrLastWorkspaceOwner), errors.Is(err, model.ErrWorkspaceNameRequired):
+		status = http.StatusUnprocessableEntity
+		message = err.Error()
+	default:
+		logger.Println("Error handling workspace:", err)
+	}
+	errorResponse := model.ErrorResponse{
+		StatusCode: status,
+		Message:    message,
+	}
+	c.JSON(status, errorResponse)
+}
+
+// requireWorkspaceOwner checks that the caller owns the active workspace. It
+// writes the error response itself and reports whether to continue.
+func requireWorkspaceOwner(c *gin.Context, principal *helper.Principal) bool {
+	if principal.WorkspaceRole != model.WorkspaceOwner {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusForbidden,
+			Message:    "only workspace owners can manage members",
+		}
+		c.JSON(http.StatusForbidden, errorResponse)
+		return false
+	}
+	return true
+}
+
+func CreateWorkspaceHandler(c *gin.Context) {
+	start := time.Now()
+	principal, err := helper.CurrentPrincipal(c)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+	var input CreateWorkspaceInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+
+	workspace, err := model.CreateWorkspace(input.Name, principal.UserID)
+	if err != nil {
+		respondWithWorkspaceError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	logger.Printf("Workspace %d created by user %d.\n", workspace.ID, principal.UserID)
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusCreated,
+		Message:    "Workspace created successfully",
+		Data:       workspace,
+	}
+	c.JSON(http.StatusCreated, successResponse)
+	observeRequestDuration(c, start)
+}
+
+// ListWorkspacesHandler lists the workspaces the caller is a member of.
+func ListWorkspacesHandler(c *gin.Context) {
+	start := time.Now()
+	principal, err := helper.CurrentPrincipal(c)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+
+	workspaces, err := model.ListWorkspacesForUser(principal.UserID)
+	if err != nil {
+		respondWithWorkspaceError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Workspaces queried successfully",
+		Data:       workspaces,
+	}
+	c.JSON(http.StatusOK, successResponse)
+	observeRequestDuration(c, start)
+}
+
+func ListWorkspaceMembersHandler(c *gin.Context) {
+	start := time.Now()
+	principal, err := helper.CurrentPrincipal(c)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+
+	members, err := model.ListWorkspaceMembers(principal.WorkspaceID)
+	if err != nil {
+		respondWithWorkspaceError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Workspace members queried successfully",
+		Data:       members,
+	}
+	c.JSON(http.StatusOK, successResponse)
+	observeRequestDuration(c, start)
+}
+
+// SetWorkspaceMemberHandler adds a user to the workspace or changes their
+// role. Only owners can.
+func SetWorkspaceMemberHandler(c *gin.Context) {
+	start := time.Now()
+	principal, err := helper.CurrentPrincipal(c)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+	var input WorkspaceMemberInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+	if !requireWorkspaceOwner(c, principal) {
+		observeRequestDuration(c, start)
+		return
+	}
+
+	member, err := model.FindUserByUsername(input.Username)
+	if err == nil && member.ID == 0 {
+		err = model.ErrUserNotFound
+	}
+	if err != nil {
+		respondWithWorkspaceError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	membership, err := model.SetWorkspaceMember(principal.WorkspaceID, member.ID, input.Role)
+	if err != nil {
+		respondWithWorkspaceError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	logger.Printf("User %d is now %s of workspace %d.\n", member.ID, input.Role, principal.WorkspaceID)
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Workspace member saved successfully",
+		Data:       membership,
+	}
+	c.JSON(http.StatusOK, successResponse)
+	observeRequestDuration(c, start)
+}
+
+// RemoveWorkspaceMemberHandler removes a user from the workspace. Owners can
+// remove anyone; other members can only leave.
+func RemoveWorkspaceMemberHandler(c *gin.Context) {
+	start := time.Now()
+	principal, err := helper.CurrentPrincipal(c)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+	memberID, err := strconv.ParseUint(c.Param("userid"), 10, 64)
+	if err != nil {
+		errorResponse := model.ErrorResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Invalid user ID",
+		}
+		c.JSON(http.StatusBadRequest, errorResponse)
+		return
+	}
+
+	if uint(memberID) != principal.UserID && !requireWorkspaceOwner(c, principal) {
+		observeRequestDuration(c, start)
+		return
+	}
+	if err := model.RemoveWorkspaceMember(principal.WorkspaceID, uint(memberID)); err != nil {
+		respondWithWorkspaceError(c, err)
+		observeRequestDuration(c, start)
+		return
+	}
+
+	logger.Printf("User %d removed from workspace %d.\n", memberID, principal.WorkspaceID)
+	successResponse := model.SuccessResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Workspace member removed successfully",
+	}
+	c.JSON(http.StatusOK, successResponse)
+	observeRequestDuration(c, start)
+}
@@ -2,40 +2,27 @@ package database
 
 import (
 	"fmt"
+	"konzek_assg/config"
 	"log"
-	"os"
 
-	"github.com/joho/godotenv"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
 var Database *gorm.DB
 
-func loadEnv() {
-	cwd, err := os.Getwd()
-	if err != nil {
-		log.Fatalf("Error getting current working directory: %v", err)
-	}
-	log.Printf("Current working directory: %s", cwd)
+// registeredModels holds the models of other packages that are migrated on
+// Connect. The model package cannot be imported here, so it registers its own
+// tables from init.
+var registeredModels []interface{}
 
-	err = godotenv.Load(".env")
-	if err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
-	}
+func RegisterModels(models ...interface{}) {
+	registeredModels = append(registeredModels, models...)
 }
 
-func Connect() (*gorm.DB, error) {
-	loadEnv()
+func Connect(cfg config.Database) (*gorm.DB, error) {
 	var err error
-	host := os.Getenv("DB_HOST")
-	username := os.Getenv("DB_USER")
-	password := os.Getenv("DB_PASSWORD")
-	databaseName := os.Getenv("DB_NAME")
-	port := os.Getenv("DB_PORT")
-
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable TimeZone=Africa/Lagos", host, username, password, databaseName, port)
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(cfg.DSN()), &gorm.Config{})
 
 	if err != nil {
 		log.Fatalf("failed to connect to database: %v", err)
@@ -57,5 +44,13 @@ func Connect() (*gorm.DB, error) {
 	}
 	log.Printf("Task table migrated successfully.")
 
+	if len(registeredModels) > 0 {
+		err = Database.AutoMigrate(registeredModels...)
+		if err != nil {
+			log.Fatalf("failed to auto migrate registered tables: %v", err)
+			return nil, err
+		}
+	}
+
 	return db, nil
 }
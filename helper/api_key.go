@@ -0,0 +1,87 @@
+package helper
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"konzek_assg/model"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyPrefix starts every API key, so keys are recognisable in the
+// Authorization header and in leaked-secret scans.
+const APIKeyPrefix = "kzk_"
+
+var (
+	ErrInvalidAPIKey = errors.New("invalid api key")
+	ErrAPIKeyExpired = errors.New("api key has expired")
+)
+
+// NewAPIKey returns a key of the form kzk_<id>_<secret>, the public id it is
+// looked up by and the hash to store.
+func NewAPIKey() (key, id, hash string, err error) {
+	idBytes := make([]byte, 6)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", "", "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+	id = APIKeyPrefix + hex.EncodeToString(idBytes)
+	key = id + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return key, id, hashRefreshToken(key), nil
+}
+
+// IsAPIKey reports whether a bearer credential is an API key rather than a
+// JWT.
+func IsAPIKey(credential string) bool {
+	return strings.HasPrefix(credential, APIKeyPrefix)
+}
+
+// AuthenticateAPIKey checks key and returns its stored record.
+func AuthenticateAPIKey(key string) (model.APIKey, error) {
+	if !IsAPIKey(key) {
+		return model.APIKey{}, ErrInvalidAPIKey
+	}
+	// The secret is base64url and may contain underscores; the id does not.
+	separator := strings.Index(key[len(APIKeyPrefix):], "_")
+	if separator <= 0 {
+		return model.APIKey{}, ErrInvalidAPIKey
+	}
+	stored, err := model.FindAPIKeyByPrefix(key[:len(APIKeyPrefix)+separator])
+	if errors.Is(err, model.ErrAPIKeyNotFound) {
+		return model.APIKey{}, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return model.APIKey{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashRefreshToken(key)), []byte(stored.KeyHash)) != 1 {
+		return model.APIKey{}, ErrInvalidAPIKey
+	}
+	now := time.Now()
+	if stored.ExpiresAt != nil && !now.Before(*stored.ExpiresAt) {
+		return model.APIKey{}, ErrAPIKeyExpired
+	}
+	if err := model.TouchAPIKey(stored.ID, now); err != nil {
+		return model.APIKey{}, err
+	}
+	return stored, nil
+}
+
+// APIKeyFromRequest returns the API key of the request, sent as a bearer
+// token or in the X-API-Key header, or "" if it carries none.
+func APIKeyFromRequest(context *gin.Context) string {
+	if key := context.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+	if token := getTokenFromRequest(context); IsAPIKey(token) {
+		return token
+	}
+	return ""
+}
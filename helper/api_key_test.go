@@ -0,0 +1,88 @@
+package helper
+
+import (
+	"errors"
+	"konzek_assg/config"
+	"konzek_assg/database"
+	"konzek_assg/model"
+	"strings"
+	"testing"
+	"time"
+)
+
+func connectDatabase(t *testing.T) {
+	cfg, err := config.Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	database.Connect(cfg.Database)
+}
+
+func TestNewAPIKey(t *testing.T) {
+	key, id, hash, err := NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsAPIKey(key) || !strings.HasPrefix(key, id+"_") {
+		t.Errorf("key %q does not start with its id %q", key, id)
+	}
+	if hash != hashRefreshToken(key) || strings.Contains(hash, key) {
+		t.Errorf("unexpected hash %q", hash)
+	}
+	if IsAPIKey("eyJhbGciOiJIUzI1NiJ9.e30.sig") {
+		t.Error("JWT taken for an API key")
+	}
+}
+
+// storeAPIKey creates an API key of the user and returns the full key and
+// its record.
+func storeAPIKey(t *testing.T, userID uint, expiresAt *time.Time) (string, model.APIKey) {
+	t.Helper()
+	key, id, hash, err := NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := model.APIKey{UserID: userID, Name: "test", Prefix: id, KeyHash: hash, Scopes: model.ScopeList{model.PermTasksRead}, ExpiresAt: expiresAt}
+	if err := model.CreateAPIKey(&record); err != nil {
+		t.Fatal(err)
+	}
+	return key, record
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	connectDatabase(t)
+
+	user := model.User{Username: "api-key-user", Password: "Api-key-pass-1"}
+	if err := database.Database.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	defer database.Database.Unscoped().Delete(&user)
+	defer database.Database.Where("user_id = ?", user.ID).Delete(&model.APIKey{})
+
+	key, record := storeAPIKey(t, user.ID, nil)
+	stored, err := AuthenticateAPIKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.ID != record.ID || stored.UserID != user.ID {
+		t.Errorf("got key %d of user %d, want key %d of user %d", stored.ID, stored.UserID, record.ID, user.ID)
+	}
+
+	if _, err := AuthenticateAPIKey(record.Prefix + "_wrong-secret"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("wrong secret: got %v, want ErrInvalidAPIKey", err)
+	}
+
+	unknown, _, _, err := NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AuthenticateAPIKey(unknown); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("unknown prefix: got %v, want ErrInvalidAPIKey", err)
+	}
+
+	expiredAt := time.Now().Add(-time.Minute)
+	expired, _ := storeAPIKey(t, user.ID, &expiredAt)
+	if _, err := AuthenticateAPIKey(expired); !errors.Is(err, ErrAPIKeyExpired) {
+		t.Errorf("expired key: got %v, want ErrAPIKeyExpired", err)
+	}
+}
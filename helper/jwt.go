@@ -1,10 +1,12 @@
 package helper
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"konzek_assg/config"
 	"konzek_assg/model"
-	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -13,59 +15,191 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 )
 
-var privateKey = []byte(os.Getenv("JWT_PRIVATE_KEY"))
+var (
+	ErrInvalidToken = errors.New("invalid token provided")
+	ErrTokenExpired = errors.New("token has expired")
+	ErrUnknownKey   = errors.New("token signed with an unknown key")
+)
 
-func GenerateJWT(user model.User) (string, error) {
-	tokenTTL, _ := strconv.Atoi(os.Getenv("TOKEN_TTL"))
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"id":  user.ID,
-		"iat": time.Now().Unix(),
-		"eat": time.Now().Add(time.Second * time.Duration(tokenTTL)).Unix(),
-	})
-	return token.SignedString(privateKey)
+var (
+	// signingKeys maps a key ID to its key. currentKeyID signs new tokens;
+	// the others only verify tokens issued before a rotation.
+	signingKeys  = map[string]signingKey{}
+	currentKeyID string
+	tokenTTL     time.Duration
+	issuer       string
+	audience     string
+	clockSkew    time.Duration
+)
+
+// Claims are the claims of the tokens issued at login. The subject is the
+// user ID; roles and permissions are those the user had when the token was
+// issued.
+type Claims struct {
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	// Purpose marks tokens that are not access tokens, such as the "mfa"
+	// token of a login waiting for its second factor.
+	Purpose string `json:"purpose,omitempty"`
+	jwt.RegisteredClaims
 }
 
-func ValidateJWT(context *gin.Context) error {
-	token, err := getToken(context)
-	if err != nil {
-		return err
+func (c *Claims) UserID() (uint, error) {
+	id, err := strconv.ParseUint(c.Subject, 10, 64)
+	if err != nil || id == 0 {
+		return 0, ErrInvalidToken
 	}
+	return uint(id), nil
+}
 
-	_, ok := token.Claims.(jwt.MapClaims)
-	if ok && token.Valid {
-		return nil
+// Configure sets the signing keys, token lifetime and expected claims. It must
+// be called before tokens are issued or validated. New tokens are signed with
+// cfg.KeyFile if set and with the cfg.PrivateKey secret otherwise.
+func Configure(cfg config.JWT) error {
+	keys := map[string]signingKey{}
+	for _, secret := range cfg.PreviousKeys {
+		key := hmacKey(secret)
+		key.sign = nil
+		keys[key.id] = key
+	}
+	for _, path := range cfg.PreviousKeyFiles {
+		key, err := loadKeyFile(path)
+		if err != nil {
+			return err
+		}
+		key.sign = nil
+		keys[key.id] = key
 	}
-	return errors.New("invalid token provided")
 
+	var current signingKey
+	if cfg.KeyFile != "" {
+		var err error
+		if current, err = loadKeyFile(cfg.KeyFile); err != nil {
+			return err
+		}
+		if current.sign == nil {
+			return fmt.Errorf("%s: %w: a private key is needed to sign tokens", cfg.KeyFile, ErrUnsupportedKey)
+		}
+	} else {
+		current = hmacKey(cfg.PrivateKey)
+	}
+	if cfg.PrivateKey != "" && cfg.KeyFile != "" {
+		// Keep accepting tokens signed with the secret while switching over.
+		key := hmacKey(cfg.PrivateKey)
+		key.sign = nil
+		keys[key.id] = key
+	}
+	keys[current.id] = current
+
+	signingKeys = keys
+	currentKeyID = current.id
+	tokenTTL = cfg.TokenTTL
+	refreshTokenTTL = cfg.RefreshTokenTTL
+	mfaTokenTTL = cfg.MFATokenTTL
+	issuer = cfg.Issuer
+	audience = cfg.Audience
+	clockSkew = cfg.ClockSkew
+	return nil
 }
 
-func CurrentUser(context *gin.Context) (model.User, error) {
-	err := ValidateJWT(context)
-	if err != nil {
-		return model.User{}, err
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
+	return hex.EncodeToString(b), nil
+}
 
-	token, _ := getToken(context)
-	claims, _ := token.Claims.(jwt.MapClaims)
-	userId := uint(claims["id"].(float64))
+// GenerateJWT issues an access token for the user. The user's roles and
+// their permissions must be loaded.
+func GenerateJWT(user model.User) (string, error) {
+	claims := Claims{
+		Roles:       user.RoleNames(),
+		Permissions: user.PermissionNames(),
+	}
+	return signToken(claims, user.ID, tokenTTL)
+}
 
-	user, err := model.FindUserById(userId)
+// signToken fills in the registered claims and signs the token with the
+// current key.
+func signToken(claims Claims, userID uint, ttl time.Duration) (string, error) {
+	id, err := newTokenID()
 	if err != nil {
-		return model.User{}, err
+		return "", err
 	}
-	return user, nil
+	now := time.Now()
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        id,
+		Subject:   strconv.FormatUint(uint64(userID), 10),
+		Issuer:    issuer,
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+	if audience != "" {
+		claims.Audience = jwt.ClaimStrings{audience}
+	}
+
+	key := signingKeys[currentKeyID]
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+	return token.SignedString(key.sign)
 }
 
-func getToken(context *gin.Context) (*jwt.Token, error) {
-	tokenString := getTokenFromRequest(context)
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+// ParseToken verifies the signature and registered claims of tokenString.
+// Tokens without an expiry are rejected.
+func ParseToken(tokenString string) (*Claims, error) {
+	parser := jwt.NewParser(jwt.WithoutClaimsValidation())
+	claims := &Claims{}
+	_, err := parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := signingKeys[kid]
+		if !ok {
+			return nil, ErrUnknownKey
 		}
-
-		return privateKey, nil
+		// The algorithm is fixed by the key, never by the token, so a public
+		// key can't be abused as an HMAC secret.
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %q for key %s", token.Method.Alg(), kid)
+		}
+		return key.verify, nil
 	})
-	return token, err
+	if err != nil {
+		if errors.Is(err, ErrUnknownKey) {
+			return nil, ErrUnknownKey
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if err := claims.validate(time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// validate checks the time-based claims with clockSkew leeway and the issuer
+// and audience against the configured ones.
+func (c *Claims) validate(now time.Time) error {
+	if !c.VerifyExpiresAt(now.Add(-clockSkew), true) {
+		return ErrTokenExpired
+	}
+	if !c.VerifyNotBefore(now.Add(clockSkew), false) || !c.VerifyIssuedAt(now.Add(clockSkew), false) {
+		return fmt.Errorf("%w: token used before it is valid", ErrInvalidToken)
+	}
+	if issuer != "" && !c.VerifyIssuer(issuer, true) {
+		return fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+	}
+	if audience != "" && !c.VerifyAudience(audience, true) {
+		return fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	}
+	if _, err := c.UserID(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func ValidateJWT(context *gin.Context) error {
+	_, err := AuthenticateRequest(context)
+	return err
 }
 
 func getTokenFromRequest(context *gin.Context) string {
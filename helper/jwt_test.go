@@ -0,0 +1,289 @@
+package helper
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"konzek_assg/config"
+	"konzek_assg/model"
+	"konzek_assg/revocation"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func testConfig(key string, previous ...string) config.JWT {
+	cfg := config.Default().JWT
+	cfg.PrivateKey = key
+	cfg.PreviousKeys = previous
+	return cfg
+}
+
+func configure(t *testing.T, cfg config.JWT) {
+	t.Helper()
+	if err := Configure(cfg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenerateAndParseToken(t *testing.T) {
+	configure(t, testConfig("secret"))
+
+	user := model.User{}
+	user.ID = 42
+	token, err := GenerateJWT(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ParseToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := claims.UserID(); id != 42 {
+		t.Errorf("subject = %q, want 42", claims.Subject)
+	}
+	if claims.ID == "" || claims.ExpiresAt == nil || claims.Issuer != "konzek_assg" {
+		t.Errorf("registered claims missing: %+v", claims.RegisteredClaims)
+	}
+}
+
+func TestExpiredTokenIsRejected(t *testing.T) {
+	cfg := testConfig("secret")
+	cfg.ClockSkew = 30 * time.Second
+	configure(t, cfg)
+
+	sign := func(expiresAt time.Time) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "1",
+			Issuer:    cfg.Issuer,
+			Audience:  jwt.ClaimStrings{cfg.Audience},
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		}})
+		token.Header["kid"] = currentKeyID
+		signed, _ := token.SignedString([]byte("secret"))
+		return signed
+	}
+
+	if _, err := ParseToken(sign(time.Now().Add(-time.Minute))); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("expired token: got %v, want ErrTokenExpired", err)
+	}
+	if _, err := ParseToken(sign(time.Now().Add(-10 * time.Second))); err != nil {
+		t.Errorf("token within the clock skew: %v", err)
+	}
+
+	legacy := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"id": 1, "eat": time.Now().Add(time.Hour).Unix()})
+	legacy.Header["kid"] = currentKeyID
+	signed, _ := legacy.SignedString([]byte("secret"))
+	if _, err := ParseToken(signed); err == nil {
+		t.Error("token without exp was accepted")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	configure(t, testConfig("old"))
+	user := model.User{}
+	user.ID = 7
+	oldToken, _ := GenerateJWT(user)
+
+	configure(t, testConfig("new", "old"))
+	if _, err := ParseToken(oldToken); err != nil {
+		t.Errorf("token signed with the previous key: %v", err)
+	}
+	newToken, _ := GenerateJWT(user)
+	if _, err := ParseToken(newToken); err != nil {
+		t.Errorf("token signed with the current key: %v", err)
+	}
+
+	configure(t, testConfig("new"))
+	if _, err := ParseToken(oldToken); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("token signed with a retired key: got %v, want ErrUnknownKey", err)
+	}
+}
+
+func writeKey(t *testing.T, dir, name string, key interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAsymmetricKeysAndJWKS(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	user := model.User{}
+	user.ID = 3
+	var previous []string
+	for name, key := range map[string]interface{}{"rsa.pem": rsaKey, "ec.pem": ecKey, "ed25519.pem": edKey} {
+		path := writeKey(t, dir, name, key)
+		cfg := testConfig("")
+		cfg.KeyFile = path
+		cfg.PreviousKeyFiles = previous
+		configure(t, cfg)
+
+		token, err := GenerateJWT(user)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if _, err := ParseToken(token); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		previous = append(previous, path)
+	}
+
+	set := JWKS()
+	if len(set.Keys) != 3 {
+		t.Fatalf("JWKS has %d keys, want 3", len(set.Keys))
+	}
+	algorithms := map[string]bool{}
+	for _, key := range set.Keys {
+		algorithms[key.Algorithm] = true
+	}
+	for _, alg := range []string{"RS256", "ES256", "EdDSA"} {
+		if !algorithms[alg] {
+			t.Errorf("JWKS has no %s key", alg)
+		}
+	}
+}
+
+func TestAlgorithmMustMatchKey(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	cfg := testConfig("")
+	cfg.KeyFile = writeKey(t, t.TempDir(), "ed25519.pem", edKey)
+	configure(t, cfg)
+
+	// Sign with HS256 using the public key bytes, the classic key confusion.
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{RegisteredClaims: jwt.RegisteredClaims{
+		Subject:   "1",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}})
+	token.Header["kid"] = currentKeyID
+	signed, _ := token.SignedString([]byte(edKey.Public().(ed25519.PublicKey)))
+	if _, err := ParseToken(signed); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("HS256 token for an EdDSA key: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestRevokedTokensAreRejected(t *testing.T) {
+	configure(t, testConfig("secret"))
+	SetDenylist(revocation.NewMemoryStore())
+	ctx := context.Background()
+
+	user := model.User{}
+	user.ID = 9
+	first, _ := GenerateJWT(user)
+	second, _ := GenerateJWT(user)
+	firstClaims, _ := ParseToken(first)
+	secondClaims, _ := ParseToken(second)
+
+	if err := RevokeToken(ctx, firstClaims); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkRevoked(ctx, firstClaims); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("revoked token: got %v, want ErrTokenRevoked", err)
+	}
+	if err := checkRevoked(ctx, secondClaims); err != nil {
+		t.Errorf("other token of the same user: %v", err)
+	}
+
+	// Logging out everywhere in the second the token was issued.
+	cutoff := secondClaims.IssuedAt.Time
+	if err := denylist.RevokeUser(ctx, 9, cutoff, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkRevoked(ctx, secondClaims); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("token issued in the second of logging out everywhere: got %v, want ErrTokenRevoked", err)
+	}
+	secondClaims.IssuedAt = jwt.NewNumericDate(cutoff.Add(-time.Second))
+	if err := checkRevoked(ctx, secondClaims); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("token issued before logging out everywhere: got %v, want ErrTokenRevoked", err)
+	}
+	secondClaims.IssuedAt = jwt.NewNumericDate(cutoff.Add(time.Second))
+	if err := checkRevoked(ctx, secondClaims); err != nil {
+		t.Errorf("token issued in the next second: %v", err)
+	}
+}
+
+func TestTokenCarriesRolesAndPermissions(t *testing.T) {
+	configure(t, testConfig("secret"))
+
+	user := model.User{Roles: []model.Role{{
+		Name:        model.RoleAdmin,
+		Permissions: []model.Permission{{Name: model.PermUsersRead}, {Name: model.PermTasksRead}},
+	}}}
+	user.ID = 5
+	token, _ := GenerateJWT(user)
+	claims, err := ParseToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claims.Roles) != 1 || claims.Roles[0] != model.RoleAdmin {
+		t.Errorf("roles = %v, want [admin]", claims.Roles)
+	}
+	if len(claims.Permissions) != 2 {
+		t.Errorf("permissions = %v, want tasks:read and users:read", claims.Permissions)
+	}
+
+	plain := model.User{}
+	plain.ID = 6
+	token, _ = GenerateJWT(plain)
+	claims, _ = ParseToken(token)
+	if len(claims.Roles) != 1 || claims.Roles[0] != model.RoleUser || len(claims.Permissions) != len(model.DefaultPermissions()) {
+		t.Errorf("user without roles got roles %v and permissions %v", claims.Roles, claims.Permissions)
+	}
+}
+
+func TestMFATokenIsNotAnAccessToken(t *testing.T) {
+	configure(t, testConfig("secret"))
+	SetDenylist(revocation.NewMemoryStore())
+	ctx := context.Background()
+
+	user := model.User{}
+	user.ID = 11
+	mfaToken, err := GenerateMFAToken(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	c.Request.Header.Set("Authorization", "Bearer "+mfaToken)
+	if _, err := AuthenticateRequest(c); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("mfa token used as access token: got %v, want ErrInvalidToken", err)
+	}
+
+	accessToken, _ := GenerateJWT(user)
+	if _, err := ParseMFAToken(ctx, accessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("access token used as mfa token: got %v, want ErrInvalidToken", err)
+	}
+
+	claims, err := ParseMFAToken(ctx, mfaToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RevokeToken(ctx, claims); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseMFAToken(ctx, mfaToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("spent mfa token: got %v, want ErrTokenRevoked", err)
+	}
+}
@@ -0,0 +1,116 @@
+package helper
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var ErrUnsupportedKey = errors.New("unsupported signing key")
+
+// signingKey is one entry of the key ring. sign is nil for keys that only
+// verify tokens issued before a rotation.
+type signingKey struct {
+	id     string
+	method jwt.SigningMethod
+	sign   interface{}
+	verify interface{}
+	// public is published in the JWKS; it is nil for shared secrets.
+	public crypto.PublicKey
+}
+
+// hmacKey derives the key ID from the secret, so every instance sharing a
+// secret agrees on it without further configuration.
+func hmacKey(secret string) signingKey {
+	sum := sha256.Sum256([]byte(secret))
+	return signingKey{
+		id:     hex.EncodeToString(sum[:8]),
+		method: jwt.SigningMethodHS256,
+		sign:   []byte(secret),
+		verify: []byte(secret),
+	}
+}
+
+// loadKeyFile reads a PEM encoded RSA, P-256 or Ed25519 key. A private key
+// can sign and verify, a public key only verify. The algorithm follows from
+// the key type.
+func loadKeyFile(path string) (signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return signingKey{}, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return signingKey{}, fmt.Errorf("%s: no PEM data found", path)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return signingKey{}, fmt.Errorf("%s: %w: PEM block %q", path, ErrUnsupportedKey, block.Type)
+	}
+	if err != nil {
+		return signingKey{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	key, err := newAsymmetricKey(parsed)
+	if err != nil {
+		return signingKey{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+func newAsymmetricKey(parsed interface{}) (signingKey, error) {
+	var key signingKey
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key = signingKey{method: jwt.SigningMethodRS256, sign: k, public: &k.PublicKey}
+	case *rsa.PublicKey:
+		key = signingKey{method: jwt.SigningMethodRS256, public: k}
+	case *ecdsa.PrivateKey:
+		key = signingKey{method: jwt.SigningMethodES256, sign: k, public: &k.PublicKey}
+	case *ecdsa.PublicKey:
+		key = signingKey{method: jwt.SigningMethodES256, public: k}
+	case ed25519.PrivateKey:
+		key = signingKey{method: jwt.SigningMethodEdDSA, sign: k, public: k.Public()}
+	case ed25519.PublicKey:
+		key = signingKey{method: jwt.SigningMethodEdDSA, public: k}
+	default:
+		return signingKey{}, fmt.Errorf("%w: %T", ErrUnsupportedKey, parsed)
+	}
+	if ec, ok := key.public.(*ecdsa.PublicKey); ok && ec.Curve != elliptic.P256() {
+		return signingKey{}, fmt.Errorf("%w: ES256 needs a P-256 key, got %s", ErrUnsupportedKey, ec.Curve.Params().Name)
+	}
+	if rsaKey, ok := key.public.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < 2048 {
+		return signingKey{}, fmt.Errorf("%w: RSA keys need at least 2048 bits", ErrUnsupportedKey)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(key.public)
+	if err != nil {
+		return signingKey{}, err
+	}
+	sum := sha256.Sum256(der)
+	// Asymmetric keys are identified by their public key the same way.
+	key.id = hex.EncodeToString(sum[:8])
+	key.verify = key.public
+	return key, nil
+}
@@ -0,0 +1,102 @@
+package helper
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"konzek_assg/model"
+	"konzek_assg/totp"
+	"strings"
+	"time"
+)
+
+const (
+	purposeMFA = "mfa"
+
+	recoveryCodeCount = 10
+)
+
+var ErrInvalidMFACode = errors.New("invalid authentication code")
+
+var mfaTokenTTL time.Duration
+
+// GenerateMFAToken issues the short-lived token a login gets after the
+// password step when the user has two-factor authentication enabled. It is
+// not an access token; it can only be exchanged with a code, see
+// ParseMFAToken.
+func GenerateMFAToken(user model.User) (string, error) {
+	return signToken(Claims{Purpose: purposeMFA}, user.ID, mfaTokenTTL)
+}
+
+// ParseMFAToken verifies a token issued by GenerateMFAToken and not yet
+// spent with RevokeToken.
+func ParseMFAToken(ctx context.Context, token string) (*Claims, error) {
+	claims, err := ParseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purposeMFA {
+		return nil, fmt.Errorf("%w: not an mfa token", ErrInvalidToken)
+	}
+	if err := checkRevoked(ctx, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// NewRecoveryCodes returns fresh recovery codes and the hashes to store.
+func NewRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := encoding.EncodeToString(b)
+		codes[i] = code[:8] + "-" + code[8:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode ignores case, dashes and spaces, which users tend to get
+// wrong when typing a code.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return hashRefreshToken(normalized)
+}
+
+// VerifySecondFactor accepts a current TOTP code of the user, each only
+// once, or one of their unused recovery codes, which is spent.
+func VerifySecondFactor(userID uint, code string) error {
+	mfa, err := model.FindMFA(userID)
+	if err != nil {
+		return err
+	}
+	code = strings.TrimSpace(code)
+	var ok bool
+	if len(code) == totp.Digits {
+		step, valid := totp.Validate(mfa.Secret, code, time.Now())
+		if valid {
+			ok, err = model.UseTOTPStep(userID, step)
+		}
+	} else {
+		ok, err = model.UseRecoveryCode(userID, hashRecoveryCode(code))
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// Issuer names this service in issued tokens and authenticator apps.
+func Issuer() string {
+	return issuer
+}
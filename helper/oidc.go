@@ -0,0 +1,106 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"konzek_assg/model"
+	"konzek_assg/oidc"
+	"strings"
+	"time"
+)
+
+// StartOIDCLogin remembers a new login with provider for ttl and returns the
+// provider URL to send the user to and the state that comes back with them.
+// linkUserID is the logged-in user linking the identity, or 0 for a login.
+func StartOIDCLogin(ctx context.Context, provider *oidc.Provider, linkUserID uint, ttl time.Duration) (authURL, state string, err error) {
+	state, hash, err := newRefreshToken()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		return "", "", err
+	}
+	authURL, err = provider.AuthCodeURL(ctx, state, nonce, challenge)
+	if err != nil {
+		return "", "", err
+	}
+	err = model.CreateOIDCLoginState(&model.OIDCLoginState{
+		StateHash:    hash,
+		Provider:     provider.Name(),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		LinkUserID:   linkUserID,
+		ExpiresAt:    time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return authURL, state, nil
+}
+
+// FinishOIDCLogin spends the state of a login coming back from provider,
+// redeems the code and returns the verified ID token with the login state.
+func FinishOIDCLogin(ctx context.Context, provider *oidc.Provider, state, code string) (*oidc.IDToken, model.OIDCLoginState, error) {
+	login, err := model.ConsumeOIDCLoginState(provider.Name(), hashRefreshToken(state))
+	if err != nil {
+		return nil, model.OIDCLoginState{}, err
+	}
+	raw, err := provider.Exchange(ctx, code, login.CodeVerifier)
+	if err != nil {
+		return nil, login, err
+	}
+	token, err := provider.VerifyIDToken(ctx, raw, login.Nonce)
+	if err != nil {
+		return nil, login, err
+	}
+	return token, login, nil
+}
+
+// OIDCUser returns the user linked to the token's subject, creating one on
+// the first login. Users are never matched by email: an address claimed at
+// a provider does not prove ownership of a local account, which has to be
+// linked explicitly.
+func OIDCUser(provider string, token *oidc.IDToken) (model.User, bool, error) {
+	user, err := model.FindUserByIdentity(provider, token.Subject)
+	if !errors.Is(err, model.ErrIdentityNotFound) {
+		return user, false, err
+	}
+
+	username := token.PreferredUsername
+	if username == "" {
+		username = emailLocalPart(token.Email)
+	}
+	// Nobody knows this password; the user can set one with a reset.
+	password, _, err := newRefreshToken()
+	if err != nil {
+		return model.User{}, false, err
+	}
+	user = model.User{Username: username, DisplayName: token.Name, PlainPassword: password}
+	if token.EmailVerified {
+		user.Email = token.Email
+	}
+	identity := model.UserIdentity{Provider: provider, Subject: token.Subject, Email: token.Email}
+	err = model.CreateUserWithIdentity(&user, &identity)
+	if errors.Is(err, model.ErrIdentityLinked) {
+		// A concurrent first login of the same subject created the user.
+		user, err = model.FindUserByIdentity(provider, token.Subject)
+		return user, false, err
+	}
+	if err != nil {
+		return model.User{}, false, err
+	}
+	user, err = model.FindUserWithRoles(user.ID)
+	return user, true, err
+}
+
+func emailLocalPart(email string) string {
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		return email[:at]
+	}
+	return email
+}
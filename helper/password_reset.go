@@ -0,0 +1,35 @@
+package helper
+
+import (
+	"konzek_assg/model"
+	"time"
+)
+
+// IssuePasswordResetToken creates a reset token for the user, valid for ttl.
+// Like refresh tokens, only its hash is stored.
+func IssuePasswordResetToken(userID uint, ttl time.Duration) (string, time.Time, error) {
+	token, hash, err := newRefreshToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().Add(ttl)
+	err = model.CreatePasswordResetToken(&model.PasswordResetToken{
+		UserID:    userID,
+		TokenHash: hash,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// PasswordResetUser returns the user token would reset the password of.
+func PasswordResetUser(token string) (model.User, error) {
+	return model.FindPasswordResetUser(hashRefreshToken(token))
+}
+
+// ResetPassword spends token and sets the new password of its user.
+func ResetPassword(token, plain string) (model.User, error) {
+	return model.ResetPassword(hashRefreshToken(token), plain)
+}
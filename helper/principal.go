@@ -0,0 +1,68 @@
+package helper
+
+import (
+	"errors"
+	"konzek_assg/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ErrNoPrincipal = errors.New("request is not authenticated")
+
+// principalKey is the gin context key JWTAuthMiddleware stores the
+// authenticated caller under.
+const principalKey = "principal"
+
+// Principal is the authenticated caller of a request, resolved once by
+// JWTAuthMiddleware.
+type Principal struct {
+	UserID      uint
+	Username    string
+	Roles       []string
+	Permissions []string
+	// TokenID is the jti of the access token the request was made with.
+	// Requests made with an API key have APIKeyID set instead, and no
+	// Claims.
+	TokenID  string
+	Claims   *Claims
+	APIKeyID uint
+	// WorkspaceID is the tenant selected by middleware.ActiveWorkspace, 0
+	// for the caller's personal tasks.
+	WorkspaceID   uint
+	WorkspaceRole model.WorkspaceRole
+}
+
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Principal) HasPermission(permission string) bool {
+	for _, granted := range p.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func SetPrincipal(context *gin.Context, principal *Principal) {
+	context.Set(principalKey, principal)
+}
+
+// CurrentPrincipal returns the caller stored by JWTAuthMiddleware.
+func CurrentPrincipal(context *gin.Context) (*Principal, error) {
+	value, ok := context.Get(principalKey)
+	if !ok {
+		return nil, ErrNoPrincipal
+	}
+	principal, ok := value.(*Principal)
+	if !ok {
+		return nil, ErrNoPrincipal
+	}
+	return principal, nil
+}
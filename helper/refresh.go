@@ -0,0 +1,73 @@
+package helper
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"konzek_assg/model"
+	"time"
+)
+
+var refreshTokenTTL time.Duration
+
+// newRefreshToken returns an opaque token and the hash it is stored under.
+// The token is random enough that a plain SHA-256 needs no salt.
+func newRefreshToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashRefreshToken(token), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueRefreshToken starts a new refresh token family for the user, as done
+// at login.
+func IssueRefreshToken(user model.User) (string, error) {
+	token, hash, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	familyID, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	err = model.CreateRefreshToken(&model.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RotateRefreshToken spends token and returns its successor together with
+// the ID of the user it belongs to.
+func RotateRefreshToken(token string) (string, uint, error) {
+	next, hash, err := newRefreshToken()
+	if err != nil {
+		return "", 0, err
+	}
+	successor := model.RefreshToken{
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	}
+	if err := model.RotateRefreshToken(hashRefreshToken(token), &successor); err != nil {
+		return "", 0, err
+	}
+	return next, successor.UserID, nil
+}
+
+// AccessTokenTTL is the lifetime of the tokens GenerateJWT issues.
+func AccessTokenTTL() time.Duration {
+	return tokenTTL
+}
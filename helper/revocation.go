@@ -0,0 +1,86 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"konzek_assg/model"
+	"konzek_assg/revocation"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+var denylist revocation.Store = revocation.NewMemoryStore()
+
+func SetDenylist(store revocation.Store) {
+	denylist = store
+}
+
+// AuthenticateRequest verifies the bearer token of the request and checks
+// that it has not been revoked, individually or by a logout of all sessions.
+func AuthenticateRequest(context *gin.Context) (*Claims, error) {
+	claims, err := ParseToken(getTokenFromRequest(context))
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, fmt.Errorf("%w: %s token is not an access token", ErrInvalidToken, claims.Purpose)
+	}
+	if err := checkRevoked(context.Request.Context(), claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func checkRevoked(ctx context.Context, claims *Claims) error {
+	if claims.ID != "" {
+		revoked, err := denylist.IsTokenRevoked(ctx, claims.ID)
+		if err != nil {
+			return err
+		}
+		if revoked {
+			return ErrTokenRevoked
+		}
+	}
+
+	userID, _ := claims.UserID()
+	before, err := denylist.UserRevokedBefore(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !before.IsZero() && claims.IssuedAt != nil && !claims.IssuedAt.After(before) {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// RevokeToken revokes a single access token until it would have expired.
+func RevokeToken(ctx context.Context, claims *Claims) error {
+	expiresAt := time.Now().Add(tokenTTL)
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	return denylist.RevokeToken(ctx, claims.ID, expiresAt.Add(clockSkew))
+}
+
+// RevokeRefreshToken revokes the refresh token and every token rotated from
+// the same login.
+func RevokeRefreshToken(token string) error {
+	return model.RevokeRefreshTokenFamilyOf(hashRefreshToken(token))
+}
+
+// RevokeAllSessions revokes every access and refresh token the user holds.
+// Access tokens issued at or before the current second are rejected until
+// the longest of them has expired. iat only has second precision, so a token
+// issued later in the same second, e.g. by logging in again at once, is
+// rejected too rather than letting one issued earlier in it through.
+func RevokeAllSessions(ctx context.Context, userID uint) error {
+	now := time.Now().Truncate(time.Second)
+	if err := denylist.RevokeUser(ctx, userID, now, now.Add(tokenTTL+clockSkew)); err != nil {
+		return err
+	}
+	return model.RevokeRefreshTokensForUser(userID)
+}
@@ -0,0 +1,227 @@
+// Package lockout slows down and then locks out password guessing. Failed
+// logins are counted per user and per client IP; each failure makes the next
+// attempt wait longer and too many lock the user or IP out for a while.
+// Attempts are counted as failed before they are checked, see Reserve, so
+// sending them in parallel does not get around the delays. Password reset
+// requests are limited the same way, see ThrottleReset.
+package lockout
+
+import (
+	"konzek_assg/config"
+	"konzek_assg/model"
+	"strconv"
+	"time"
+)
+
+// Policy decides how long a throttled user or IP has to wait.
+type Policy struct {
+	// MaxAttempts failures within Window lock out for Duration; 0 never
+	// locks out.
+	MaxAttempts int
+	Window      time.Duration
+	Duration    time.Duration
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var (
+	userPolicy      Policy
+	ipPolicy        Policy
+	resetUserPolicy Policy
+	resetIPPolicy   Policy
+	now             = time.Now
+)
+
+func init() {
+	Configure(config.Default().Login)
+	ConfigureResets(config.Default().Password)
+}
+
+func Configure(cfg config.Login) {
+	userPolicy = Policy{
+		MaxAttempts: cfg.MaxAttempts,
+		Window:      cfg.AttemptWindow,
+		Duration:    cfg.LockoutDuration,
+		BaseDelay:   cfg.BaseDelay,
+		MaxDelay:    cfg.MaxDelay,
+	}
+	ipPolicy = userPolicy
+	ipPolicy.MaxAttempts = cfg.IPMaxAttempts
+}
+
+// ConfigureResets sets how many password reset requests are let through.
+// They are not delayed, only held off once there were too many.
+func ConfigureResets(cfg config.Password) {
+	resetUserPolicy = Policy{
+		MaxAttempts: cfg.ResetMaxRequests,
+		Window:      cfg.ResetRequestWindow,
+		Duration:    cfg.ResetRequestWindow,
+	}
+	resetIPPolicy = resetUserPolicy
+	resetIPPolicy.MaxAttempts = cfg.ResetIPMaxRequests
+}
+
+// Wait returns how long to wait before the next attempt is allowed, 0 if it
+// is allowed now.
+func (p Policy) Wait(throttle model.LoginThrottle, at time.Time) time.Duration {
+	if at.Before(throttle.LockedUntil) {
+		return throttle.LockedUntil.Sub(at)
+	}
+	if throttle.Failures == 0 || at.Sub(throttle.LastFailureAt) >= p.Window {
+		return 0
+	}
+	next := throttle.LastFailureAt.Add(p.delay(throttle.Failures))
+	if at.Before(next) {
+		return next.Sub(at)
+	}
+	return 0
+}
+
+// Fail counts a failed attempt made at at. Reaching MaxAttempts starts a
+// lockout and the count over.
+func (p Policy) Fail(throttle *model.LoginThrottle, at time.Time) {
+	p.Reserve(throttle, at)
+	p.Lock(throttle, at)
+}
+
+// Reserve counts an attempt made at at as failed before it is checked, so
+// attempts running at the same time wait for each other.
+func (p Policy) Reserve(throttle *model.LoginThrottle, at time.Time) {
+	if at.Sub(throttle.LastFailureAt) >= p.Window {
+		throttle.Failures = 0
+	}
+	throttle.Failures++
+	throttle.LastFailureAt = at
+}
+
+// Lock starts a lockout, and the count over, once the counted failures
+// reach MaxAttempts.
+func (p Policy) Lock(throttle *model.LoginThrottle, at time.Time) {
+	if p.MaxAttempts > 0 && throttle.Failures >= p.MaxAttempts {
+		throttle.LockedUntil = at.Add(p.Duration)
+		throttle.Failures = 0
+	}
+}
+
+// Release takes back a reserved attempt that turned out to be right.
+func (p Policy) Release(throttle *model.LoginThrottle) {
+	if throttle.Failures > 0 {
+		throttle.Failures--
+	}
+}
+
+// delay is BaseDelay doubled for every failure after the first, capped at
+// MaxDelay.
+func (p Policy) delay(failures int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// userKey keys the throttle of a user by ID, so it follows them across a
+// change of username and a freed name does not inherit it. Usernames that
+// do not exist are throttled by name.
+func userKey(userID uint, username string) string {
+	if userID == 0 {
+		return "name:" + username
+	}
+	return "user:" + strconv.FormatUint(uint64(userID), 10)
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Reservation is an attempt counted by Reserve as failed until it is
+// released.
+type Reservation struct {
+	user, ip string
+}
+
+// Reserve returns how long the attempt has to wait, the longer of the waits
+// of its user and of its IP. If it need not wait, the attempt is counted as
+// failed at once, under the throttles' row locks, so attempts sent in
+// parallel cannot all pass before any failure is recorded. A right attempt
+// is taken back with Release; a wrong one is confirmed with Fail.
+func Reserve(attempt model.LoginAttempt) (Reservation, time.Duration, error) {
+	reservation := Reservation{user: userKey(attempt.UserID, attempt.Username), ip: ipKey(attempt.IP)}
+	at := now()
+	var wait time.Duration
+	err := model.UpdateLoginThrottles([]string{reservation.user, reservation.ip}, func(throttles []*model.LoginThrottle) {
+		user, client := throttles[0], throttles[1]
+		wait = userPolicy.Wait(*user, at)
+		if ipWait := ipPolicy.Wait(*client, at); ipWait > wait {
+			wait = ipWait
+		}
+		if wait > 0 {
+			return
+		}
+		userPolicy.Reserve(user, at)
+		ipPolicy.Reserve(client, at)
+	})
+	if err != nil {
+		return Reservation{}, 0, err
+	}
+	return reservation, wait, nil
+}
+
+// Release takes back the reserved attempt, which was right.
+func (r Reservation) Release() error {
+	return model.UpdateLoginThrottles([]string{r.user, r.ip}, func(throttles []*model.LoginThrottle) {
+		userPolicy.Release(throttles[0])
+		ipPolicy.Release(throttles[1])
+	})
+}
+
+// Fail records the failed attempt in the audit log and, unless it was
+// refused for being locked out, locks its user and IP out if the failure
+// Reserve counted was one too many.
+func Fail(attempt model.LoginAttempt) error {
+	if err := model.CreateLoginAttempt(&attempt); err != nil {
+		return err
+	}
+	if attempt.Reason == model.LoginLockedOut {
+		return nil
+	}
+	at := now()
+	return model.UpdateLoginThrottles([]string{userKey(attempt.UserID, attempt.Username), ipKey(attempt.IP)}, func(throttles []*model.LoginThrottle) {
+		userPolicy.Lock(throttles[0], at)
+		ipPolicy.Lock(throttles[1], at)
+	})
+}
+
+// Succeed forgets the failures of the user. Those of the IP are kept, so one
+// valid account does not reset guessing at others.
+func Succeed(userID uint) error {
+	return model.DeleteLoginThrottle(userKey(userID, ""))
+}
+
+// ThrottleReset returns how long a password reset request for username
+// from ip has to wait, 0 if it may go ahead, in which case it is counted.
+// The username is counted whether it exists or not, so the answer does not
+// tell.
+func ThrottleReset(username, ip string) (time.Duration, error) {
+	at := now()
+	var wait time.Duration
+	err := model.UpdateLoginThrottles([]string{"reset:name:" + username, "reset:" + ipKey(ip)}, func(throttles []*model.LoginThrottle) {
+		user, client := throttles[0], throttles[1]
+		wait = resetUserPolicy.Wait(*user, at)
+		if ipWait := resetIPPolicy.Wait(*client, at); ipWait > wait {
+			wait = ipWait
+		}
+		if wait > 0 {
+			return
+		}
+		resetUserPolicy.Fail(user, at)
+		resetIPPolicy.Fail(client, at)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return wait, nil
+}
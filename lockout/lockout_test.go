@@ -0,0 +1,115 @@
+package lockout
+
+import (
+	"konzek_assg/config"
+	"konzek_assg/model"
+	"testing"
+	"time"
+)
+
+func TestPolicyDelaysThenLocksOut(t *testing.T) {
+	policy := Policy{
+		MaxAttempts: 4,
+		Window:      time.Hour,
+		Duration:    10 * time.Minute,
+		BaseDelay:   time.Second,
+		MaxDelay:    3 * time.Second,
+	}
+	now := time.Now()
+	var throttle model.LoginThrottle
+
+	if wait := policy.Wait(throttle, now); wait != 0 {
+		t.Fatalf("fresh throttle waits %v", wait)
+	}
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+		policy.Fail(&throttle, now)
+		if wait := policy.Wait(throttle, now); wait != want {
+			t.Errorf("after %d failures waits %v, want %v", i+1, wait, want)
+		}
+	}
+	if wait := policy.Wait(throttle, now.Add(3*time.Second)); wait != 0 {
+		t.Errorf("still waiting %v once the delay has passed", wait)
+	}
+
+	policy.Fail(&throttle, now)
+	if wait := policy.Wait(throttle, now.Add(time.Minute)); wait != 9*time.Minute {
+		t.Errorf("locked out for another %v, want 9m", wait)
+	}
+	if wait := policy.Wait(throttle, now.Add(10*time.Minute)); wait != 0 {
+		t.Errorf("lockout outlasted its duration by %v", wait)
+	}
+}
+
+func TestPolicyForgetsFailuresOutsideWindow(t *testing.T) {
+	policy := Policy{MaxAttempts: 2, Window: time.Minute, Duration: time.Hour, BaseDelay: time.Second, MaxDelay: time.Second}
+	now := time.Now()
+	var throttle model.LoginThrottle
+
+	policy.Fail(&throttle, now)
+	policy.Fail(&throttle, now.Add(2*time.Minute))
+	if throttle.Failures != 1 || !throttle.LockedUntil.IsZero() {
+		t.Errorf("failure outside the window was counted: %+v", throttle)
+	}
+}
+
+func TestPolicyReservedAttemptsWaitForEachOther(t *testing.T) {
+	policy := Policy{MaxAttempts: 3, Window: time.Hour, Duration: time.Hour, BaseDelay: time.Second, MaxDelay: time.Minute}
+	now := time.Now()
+	var throttle model.LoginThrottle
+
+	policy.Reserve(&throttle, now)
+	if wait := policy.Wait(throttle, now); wait != time.Second {
+		t.Errorf("attempt in parallel with a reserved one waits %v, want 1s", wait)
+	}
+	policy.Release(&throttle)
+	if wait := policy.Wait(throttle, now); wait != 0 || throttle.Failures != 0 {
+		t.Errorf("released attempt still counted: waits %v with %d failures", wait, throttle.Failures)
+	}
+	policy.Release(&throttle)
+	if throttle.Failures != 0 {
+		t.Errorf("release took the count below zero: %d", throttle.Failures)
+	}
+
+	// Failures are only locked out once confirmed.
+	for i := 0; i < 3; i++ {
+		policy.Reserve(&throttle, now)
+	}
+	if !throttle.LockedUntil.IsZero() {
+		t.Fatal("reserving locked out")
+	}
+	policy.Lock(&throttle, now)
+	if wait := policy.Wait(throttle, now); wait != time.Hour {
+		t.Errorf("confirmed failure locked out for %v, want 1h", wait)
+	}
+}
+
+func TestUserThrottleFollowsTheID(t *testing.T) {
+	if userKey(7, "alice") != userKey(7, "bob") {
+		t.Error("renaming the user changes their throttle")
+	}
+	if userKey(0, "alice") == userKey(7, "alice") {
+		t.Error("a user shares the throttle of their username before it existed")
+	}
+	if userKey(0, "7") == userKey(7, "") {
+		t.Error("the username 7 shares the throttle of user 7")
+	}
+}
+
+func TestResetRequestsAreHeldOffNotDelayed(t *testing.T) {
+	cfg := config.Default().Password
+	cfg.ResetMaxRequests = 2
+	cfg.ResetRequestWindow = time.Hour
+	ConfigureResets(cfg)
+	defer ConfigureResets(config.Default().Password)
+	now := time.Now()
+	var throttle model.LoginThrottle
+
+	resetUserPolicy.Fail(&throttle, now)
+	if wait := resetUserPolicy.Wait(throttle, now); wait != 0 {
+		t.Errorf("request under the limit waits %v", wait)
+	}
+	resetUserPolicy.Fail(&throttle, now)
+	if wait := resetUserPolicy.Wait(throttle, now.Add(time.Minute)); wait != 59*time.Minute {
+		t.Errorf("request over the limit waits %v, want 59m", wait)
+	}
+}
@@ -1,14 +1,27 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"konzek_assg/config"
 	"konzek_assg/controller"
 	"konzek_assg/database"
+	"konzek_assg/helper"
+	"konzek_assg/lockout"
 	"konzek_assg/middleware"
 	"konzek_assg/model"
+	"konzek_assg/notify"
+	"konzek_assg/oidc"
+	"konzek_assg/password"
+	"konzek_assg/revocation"
 	WORKER "konzek_assg/worker"
 	"log"
+	"net/http"
 	"os"
-	"sync"
+	"os/signal"
+	"syscall"
 
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
@@ -18,9 +31,23 @@ import (
 
 var db *gorm.DB
 var logger *log.Logger
+var cfg *config.Config
+
+func loadConfig() {
+	var err error
+	cfg, err = config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintf(os.Stderr, "Usage of %s [flags] [command]:\n%s\nCommands:\n  hash-passwords\n\thash passwords stored in plaintext and exit\n", os.Args[0], config.Usage())
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+}
 
 func initLogger() {
-	logFile, err := os.OpenFile("app.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	logFile, err := os.OpenFile(cfg.Server.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
 		log.Fatal("Failed to open log file: ", err)
 	}
@@ -32,29 +59,119 @@ func initLogger() {
 }
 
 func main() {
+	loadConfig()
 	initLogger()
+	if err := helper.Configure(cfg.JWT); err != nil {
+		log.Fatalf("failed to load JWT signing keys: %v", err)
+	}
+	if err := password.Configure(cfg.Password); err != nil {
+		log.Fatalf("failed to load the password policy: %v", err)
+	}
+	lockout.Configure(cfg.Login)
+	lockout.ConfigureResets(cfg.Password)
+	controller.Configure(cfg.Server)
 	loadDatabase()
-	prometheus.MustRegister(controller.DurationOfRequest)
+	if args := config.Args(os.Args[1:]); len(args) > 0 {
+		runCommand(args)
+		return
+	}
+	prometheus.MustRegister(controller.DurationOfRequest, WORKER.BusyWorkers, WORKER.IdleWorkers, WORKER.QueueDepth)
 	serveApplication()
 }
 
 func loadDatabase() {
-	db, _ = database.Connect()
+	db, _ = database.Connect(cfg.Database)
+	if err := model.SeedRoles(); err != nil {
+		log.Fatalf("failed to seed roles: %v", err)
+	}
+	if err := model.GrantRole(model.RoleAdmin, cfg.Server.AdminUserIDs); err != nil {
+		log.Fatalf("failed to grant the admin role: %v", err)
+	}
+	if cfg.JWT.RevocationBackend == "postgres" {
+		helper.SetDenylist(revocation.NewPostgresStore(db))
+	}
 }
 
-func serveApplication() {
-	const numWorkers = 5
-	taskCh := make(chan model.Task)
-	var wg sync.WaitGroup
-	controller.InitializeController()
-	controller.SetTaskChannel(taskCh)
+// runCommand runs a one-off maintenance command instead of the server.
+func runCommand(args []string) {
+	switch args[0] {
+	case "hash-passwords":
+		hashed, err := model.HashPlaintextPasswords()
+		if err != nil {
+			logf("Hashed %d plaintext passwords before failing: %v", hashed, err)
+			os.Exit(1)
+		}
+		logf("Hashed %d plaintext passwords.", hashed)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		os.Exit(2)
+	}
+}
+
+func poolConfig() WORKER.PoolConfig {
+	return WORKER.PoolConfig{
+		MinWorkers:         cfg.Worker.MinWorkers,
+		MaxWorkers:         cfg.Worker.MaxWorkers,
+		QueueCapacity:      cfg.Worker.QueueCapacity,
+		ScaleInterval:      WORKER.DefaultPoolConfig.ScaleInterval,
+		ScaleUpLatency:     cfg.Worker.ScaleUpLatency,
+		IdleTimeout:        cfg.Worker.IdleTimeout,
+		MaxInFlightPerUser: cfg.Worker.MaxInFlightPerUser,
+		RetryAfter:         cfg.Worker.RetryAfter,
+	}
+}
+
+func newQueue() WORKER.Queue {
+	if cfg.Worker.QueueBackend == "postgres" {
+		return WORKER.NewPostgresQueue(db, cfg.Worker.QueuePollInterval, cfg.Worker.QueueVisibilityTimeout)
+	}
+	return WORKER.NewMemoryQueue(cfg.Worker.QueueCapacity)
+}
+
+func newNotifier() notify.Notifier {
+	if cfg.Password.ResetNotifier == "file" {
+		return notify.NewFileNotifier(cfg.Password.ResetNotifierFile)
+	}
+	return notify.NewLogNotifier(logger)
+}
 
-	for i := 0; i < numWorkers; i++ {
-		wg.Add(1)
-		go WORKER.Work(taskCh, &wg)
+func serveApplication() {
+	pool, err := WORKER.NewPool(newQueue(), poolConfig())
+	if err != nil {
+		log.Fatalf("failed to create worker pool: %v", err)
+	}
+	pool.Start()
+	controller.SetPool(pool)
+	controller.ConfigurePasswordReset(cfg.Password.ResetTokenTTL, newNotifier())
+	deletionPolicy := model.DeletionPolicy{Mode: cfg.Account.DeletionMode, ReassignTo: cfg.Account.ReassignTo}
+	if err := deletionPolicy.Check(); err != nil {
+		log.Fatalf("invalid account deletion policy: %v", err)
+	}
+	controller.ConfigureAccountDeletion(deletionPolicy)
+	if cfg.OIDC.Issuer != "" {
+		controller.ConfigureOIDC(cfg.OIDC.StateTTL, oidc.NewProvider(oidc.Config{
+			Name:         cfg.OIDC.ProviderName,
+			Issuer:       cfg.OIDC.Issuer,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  cfg.OIDC.RedirectURL,
+			Scopes:       cfg.OIDC.Scopes,
+		}, nil))
 	}
 
 	router := gin.Default()
+	// The client IP counts failed logins, so only proxies we run may set it.
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatalf("invalid trusted proxies: %v", err)
+	}
+
+	// JWKSHandler publishes the public keys that verify issued tokens.
+	// @Summary JSON Web Key Set
+	// @Description Public keys, selected by the token's kid header, for RS256, ES256 and EdDSA tokens.
+	// @Produce json
+	// @Success 200 {object} JSONWebKeySet "Key set"
+	// @Router /.well-known/jwks.json [get]
+	router.GET("/.well-known/jwks.json", controller.JWKSHandler)
 
 	publicRoutes := router.Group("/auth")
 
@@ -64,21 +181,170 @@ func serveApplication() {
 	// @Produce json
 	// @Param input body AuthenticationInput true "User credentials"
 	// @Success 201 {object} SuccessResponse "User created successfully."
+	// @Failure 422 {object} ErrorResponse "Password does not meet the policy"
 	// @Router /auth/register [post]
 	publicRoutes.POST("/register", controller.RegisterHandler(logger))
 	// LoginHandler handles user login.
 	// @Summary Log User In
-	// @Description Log in a user with provided credentials.
+	// @Description Log in a user with provided credentials. Failed attempts are counted per user and client IP, including attempts sent in parallel; each one delays the next and too many lock out temporarily.
 	// @Accept json
 	// @Produce json
 	// @Param input body AuthenticationInput true "User credentials"
-	// @Success 200 {object} SuccessResponse "User logged in successfully."
+	// @Success 200 {object} SuccessResponse "User logged in successfully; returns an access token (jwt) and a refresh token, or mfa_required and an mfa_token for /auth/mfa/verify."
 	// @Header 200 {string} Token "Bearer" "Authentication token"
+	// @Failure 401 {object} ErrorResponse "Invalid username or password; the same message whether the user exists or not"
+	// @Failure 429 {object} ErrorResponse "Too many failed attempts; see Retry-After"
 	// @Router /auth/login [post]
 	publicRoutes.POST("/login", controller.LoginHandler(logger))
+	// RefreshHandler rotates a refresh token.
+	// @Summary Refresh Token
+	// @Description Exchange a refresh token for a new access token and refresh token. A refresh token works once; reusing one revokes all tokens from the same login.
+	// @Accept json
+	// @Produce json
+	// @Param input body RefreshInput true "Refresh token"
+	// @Success 200 {object} SuccessResponse "Token refreshed successfully."
+	// @Failure 401 {object} ErrorResponse "Invalid, expired or reused refresh token"
+	// @Router /auth/refresh [post]
+	publicRoutes.POST("/refresh", controller.RefreshHandler(logger))
+	// LogoutHandler revokes the current access token.
+	// @Summary Log Out
+	// @Description Revoke the access token of the request and, if given, the refresh token issued with it.
+	// @Security ApiKeyAuth
+	// @Accept json
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param input body LogoutInput false "Refresh token to revoke"
+	// @Success 200 {object} SuccessResponse "Logged out successfully."
+	// @Failure 401 {object} ErrorResponse "Authentication required"
+	// @Router /auth/logout [post]
+	publicRoutes.POST("/logout", middleware.JWTAuthMiddleware(), middleware.RequireAccessToken(), controller.LogoutHandler(logger))
+	// LogoutAllHandler revokes every token of the current user.
+	// @Summary Log Out All Sessions
+	// @Description Revoke every access and refresh token of the current user.
+	// @Security ApiKeyAuth
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Success 200 {object} SuccessResponse "Logged out of all sessions successfully."
+	// @Failure 401 {object} ErrorResponse "Authentication required"
+	// @Router /auth/logout-all [post]
+	publicRoutes.POST("/logout-all", middleware.JWTAuthMiddleware(), middleware.RequireAccessToken(), controller.LogoutAllHandler(logger))
+	// ChangePasswordHandler changes the password of the current user.
+	// @Summary Change Password
+	// @Description Set a new password, giving the current one. Every session is logged out afterwards.
+	// @Security ApiKeyAuth
+	// @Accept json
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param input body ChangePasswordInput true "Current and new password"
+	// @Success 200 {object} SuccessResponse "Password changed successfully."
+	// @Failure 401 {object} ErrorResponse "Current password is incorrect"
+	// @Failure 422 {object} ErrorResponse "New password does not meet the policy"
+	// @Router /auth/password/change [post]
+	publicRoutes.POST("/password/change", middleware.JWTAuthMiddleware(), middleware.RequireAccessToken(), controller.ChangePasswordHandler(logger))
+	// RequestPasswordResetHandler sends a password reset token.
+	// @Summary Request Password Reset
+	// @Description Send a single-use reset token to the user through the configured notifier. The answer, and how long it takes, does not reveal whether the user exists. Requests are limited per username and per client IP.
+	// @Accept json
+	// @Produce json
+	// @Param input body PasswordResetRequestInput true "Username"
+	// @Success 202 {object} SuccessResponse "Reset token sent if the account exists."
+	// @Failure 429 {object} ErrorResponse "Too many reset requests; see Retry-After"
+	// @Router /auth/password/reset/request [post]
+	publicRoutes.POST("/password/reset/request", controller.RequestPasswordResetHandler(logger))
+	// ResetPasswordHandler sets a new password with a reset token.
+	// @Summary Reset Password
+	// @Description Set a new password with a reset token. The token works once, and every session of the user is logged out.
+	// @Accept json
+	// @Produce json
+	// @Param input body PasswordResetInput true "Reset token and new password"
+	// @Success 200 {object} SuccessResponse "Password reset successfully."
+	// @Failure 401 {object} ErrorResponse "Invalid or expired reset token"
+	// @Failure 422 {object} ErrorResponse "New password does not meet the policy"
+	// @Router /auth/password/reset [post]
+	publicRoutes.POST("/password/reset", controller.ResetPasswordHandler(logger))
+	// VerifyMFAHandler completes a login that needs a second factor.
+	// @Summary Verify Second Factor
+	// @Description Exchange the mfa_token returned by /auth/login and a TOTP or recovery code for the access and refresh tokens. Wrong codes count towards the login lockout.
+	// @Accept json
+	// @Produce json
+	// @Param input body MFALoginInput true "mfa token and code"
+	// @Success 200 {object} SuccessResponse "User logged in successfully."
+	// @Failure 401 {object} ErrorResponse "Invalid code or mfa token"
+	// @Failure 429 {object} ErrorResponse "Too many failed attempts; see Retry-After"
+	// @Router /auth/mfa/verify [post]
+	publicRoutes.POST("/mfa/verify", controller.VerifyMFAHandler(logger))
+	// EnrollMFAHandler starts enabling two-factor authentication.
+	// @Summary Enroll Two-Factor Authentication
+	// @Description Create a TOTP secret, returned with an otpauth URI for authenticator apps. It takes effect once confirmed.
+	// @Security ApiKeyAuth
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Success 200 {object} SuccessResponse "Secret and otpauth URI."
+	// @Failure 409 {object} ErrorResponse "Already enabled"
+	// @Router /auth/mfa/enroll [post]
+	publicRoutes.POST("/mfa/enroll", middleware.JWTAuthMiddleware(), middleware.RequireAccessToken(), controller.EnrollMFAHandler(logger))
+	// ConfirmMFAHandler enables two-factor authentication.
+	// @Summary Confirm Two-Factor Authentication
+	// @Description Enable two-factor authentication with a code from the authenticator app. Returns one-time recovery codes, shown only once.
+	// @Security ApiKeyAuth
+	// @Accept json
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param input body MFACodeInput true "TOTP code"
+	// @Success 200 {object} SuccessResponse "Recovery codes."
+	// @Failure 401 {object} ErrorResponse "Invalid code"
+	// @Failure 404 {object} ErrorResponse "No enrollment to confirm"
+	// @Router /auth/mfa/confirm [post]
+	publicRoutes.POST("/mfa/confirm", middleware.JWTAuthMiddleware(), middleware.RequireAccessToken(), controller.ConfirmMFAHandler(logger))
+	// DisableMFAHandler turns two-factor authentication off.
+	// @Summary Disable Two-Factor Authentication
+	// @Description Turn two-factor authentication off. Wrong passwords and codes count towards the same lockout as wrong passwords at login.
+	// @Security ApiKeyAuth
+	// @Accept json
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param input body DisableMFAInput true "Password and TOTP or recovery code"
+	// @Success 200 {object} SuccessResponse "Two-factor authentication disabled."
+	// @Failure 401 {object} ErrorResponse "Wrong password or code"
+	// @Failure 429 {object} ErrorResponse "Too many failed attempts; see Retry-After"
+	// @Router /auth/mfa/disable [post]
+	publicRoutes.POST("/mfa/disable", middleware.JWTAuthMiddleware(), middleware.RequireAccessToken(), controller.DisableMFAHandler(logger))
+	// OIDCLoginHandler starts a login through an external identity provider.
+	// @Summary Log In With Identity Provider
+	// @Description Redirect the browser to the OpenID Connect provider, using the authorization code flow with PKCE. The provider sends it back to the callback.
+	// @Param provider path string true "Provider name"
+	// @Success 302 "Redirect to the provider"
+	// @Failure 404 {object} ErrorResponse "Unknown provider"
+	// @Router /auth/oidc/{provider}/login [get]
+	publicRoutes.GET("/oidc/:provider/login", controller.OIDCLoginHandler(logger))
+	// OIDCCallbackHandler completes a login or link at an identity provider.
+	// @Summary Identity Provider Callback
+	// @Description Verify the provider's ID token and log in the linked user, creating one on first login, or link the identity for /auth/oidc/{provider}/link. Users with two-factor authentication get an mfa_token like /auth/login.
+	// @Produce json
+	// @Param provider path string true "Provider name"
+	// @Param code query string true "Authorization code"
+	// @Param state query string true "State"
+	// @Success 200 {object} SuccessResponse "User logged in successfully, or account linked."
+	// @Failure 401 {object} ErrorResponse "Invalid state or ID token"
+	// @Failure 409 {object} ErrorResponse "Identity already linked to another user"
+	// @Router /auth/oidc/{provider}/callback [get]
+	publicRoutes.GET("/oidc/:provider/callback", controller.OIDCCallbackHandler(logger))
+	// OIDCLinkHandler starts linking the caller to an external identity.
+	// @Summary Link Identity Provider
+	// @Description Return the provider URL to open in the same browser; logging in there links that identity to the caller.
+	// @Security ApiKeyAuth
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param provider path string true "Provider name"
+	// @Success 200 {object} SuccessResponse "authorization_url to open."
+	// @Failure 404 {object} ErrorResponse "Unknown provider"
+	// @Router /auth/oidc/{provider}/link [post]
+	publicRoutes.POST("/oidc/:provider/link", middleware.JWTAuthMiddleware(), middleware.RequireAccessToken(), controller.OIDCLinkHandler(logger))
 
 	protectedRoutes := router.Group("/api")
-	protectedRoutes.Use(middleware.JWTAuthMiddleware())
+	// X-Workspace-ID points the task routes at a workspace instead of the
+	// caller's personal tasks.
+	protectedRoutes.Use(middleware.JWTAuthMiddleware(), middleware.ActiveWorkspace(""))
 
 	// CreateTaskHandler handles the creation of a new task.
 	// @Summary Create Task
@@ -87,11 +353,16 @@ func serveApplication() {
 	// @Accept json
 	// @Produce json
 	// @Param Authorization header string true "Bearer token"
+	// @Param X-Workspace-ID header int false "Workspace to act in instead of your personal tasks"
 	// @Param task body Task true "Task object to create"
-	// @Success 200 {object} SuccessResponse "Task created successfully."
+	// @Success 201 {object} SuccessResponse "Task created successfully."
 	// @Failure 400 {object} ErrorResponse "Bad request"
+	// @Failure 422 {object} ErrorResponse "Invalid task"
+	// @Failure 504 {object} ErrorResponse "Worker pool timed out"
+	// @Success 202 {object} SuccessResponse "Accepted for asynchronous processing; see the Location header."
+	// @Failure 429 {object} ErrorResponse "Too many jobs in flight for this user; see Retry-After"
 	// @Router /api/entry [post]
-	protectedRoutes.POST("/entry", controller.CreateTaskHandler)
+	protectedRoutes.POST("/entry", middleware.RequirePermission(model.PermTasksWrite), controller.CreateTaskHandler)
 
 	// GetTasksHandler handles fetching tasks for the authenticated user.
 	// @Summary Get User Tasks
@@ -100,10 +371,11 @@ func serveApplication() {
 	// @Accept json
 	// @Produce json
 	// @Param Authorization header string true "Bearer token"
-	// @Success 200 {object} SuccessResponse "Tasks queried successfully."
+	// @Param X-Workspace-ID header int false "Workspace to act in instead of your personal tasks"
+	// @Success 200 {object} SuccessResponse "Own tasks and tasks shared with the user, the latter flagged with shared and share_level; empty list when there are none."
 	// @Failure 400 {object} ErrorResponse "Bad request"
 	// @Router /api/entry [get]
-	protectedRoutes.GET("/entry", controller.GetTasksHandler)
+	protectedRoutes.GET("/entry", middleware.RequirePermission(model.PermTasksRead), controller.GetTasksHandler)
 	// DeleteTaskHandler handles the deletion of a task by ID.
 	// @Summary Delete Task
 	// @Description Delete a task by its ID.
@@ -111,11 +383,16 @@ func serveApplication() {
 	// @Accept json
 	// @Produce json
 	// @Param Authorization header string true "Bearer token"
+	// @Param X-Workspace-ID header int false "Workspace to act in instead of your personal tasks"
 	// @Param taskid path int true "Task ID to delete"
-	// @Success 200 {object} SuccessResponse "Task deletion request sent successfully."
+	// @Success 200 {object} SuccessResponse "Task deleted successfully."
 	// @Failure 400 {object} ErrorResponse "Bad request"
+	// @Failure 403 {object} ErrorResponse "Only the owner can delete a task"
+	// @Failure 404 {object} ErrorResponse "Task not found"
+	// @Success 202 {object} SuccessResponse "Accepted for asynchronous processing; see the Location header."
+	// @Failure 429 {object} ErrorResponse "Too many jobs in flight for this user; see Retry-After"
 	// @Router /api/entry/{taskid} [delete]
-	protectedRoutes.DELETE("/entry/:taskid", controller.DeleteTaskHandler)
+	protectedRoutes.DELETE("/entry/:taskid", middleware.RequirePermission(model.PermTasksWrite), controller.DeleteTaskHandler)
 	// UpdateTaskHandler handles updating a task by ID.
 	// @Summary Update Task
 	// @Description Update a task by its ID.
@@ -123,12 +400,420 @@ func serveApplication() {
 	// @Accept json
 	// @Produce json
 	// @Param Authorization header string true "Bearer token"
+	// @Param X-Workspace-ID header int false "Workspace to act in instead of your personal tasks"
 	// @Param taskid path int true "Task ID to update"
 	// @Param task body Task true "Task object containing updated data"
-	// @Success 200 {object} SuccessResponse "Task update request sent successfully."
+	// @Success 200 {object} SuccessResponse "Task updated successfully."
 	// @Failure 400 {object} ErrorResponse "Bad request"
+	// @Failure 403 {object} ErrorResponse "Task belongs to another user and is not shared with you as editor"
+	// @Failure 404 {object} ErrorResponse "Task not found"
+	// @Failure 422 {object} ErrorResponse "Invalid task or status transition"
+	// @Success 202 {object} SuccessResponse "Accepted for asynchronous processing; see the Location header."
+	// @Failure 429 {object} ErrorResponse "Too many jobs in flight for this user; see Retry-After"
 	// @Router /api/entry/{taskid} [put]
-	protectedRoutes.PUT("/entry/:taskid", controller.UpdateTaskHandler)
+	protectedRoutes.PUT("/entry/:taskid", middleware.RequirePermission(model.PermTasksWrite), controller.UpdateTaskHandler)
+
+	// ShareTaskHandler shares a task with another user.
+	// @Summary Share Task
+	// @Description Share one of your tasks with another user as viewer (read only) or editor (read and update). Sharing again changes the level. Needs an access token; API keys cannot share.
+	// @Security ApiKeyAuth
+	// @Accept json
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param taskid path int true "Task ID"
+	// @Param input body ShareTaskInput true "Collaborator and level"
+	// @Success 200 {object} SuccessResponse "Task shared successfully."
+	// @Failure 403 {object} ErrorResponse "Only the owner can share a task"
+	// @Failure 404 {object} ErrorResponse "Task or user not found"
+	// @Failure 422 {object} ErrorResponse "Invalid share level"
+	// @Router /api/entry/{taskid}/shares [post]
+	protectedRoutes.POST("/entry/:taskid/shares", middleware.RequireAccessToken(), middleware.RequirePermission(model.PermTasksWrite), controller.ShareTaskHandler)
+	// ListTaskSharesHandler lists who a task is shared with.
+	// @Summary List Task Shares
+	// @Security ApiKeyAuth
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param taskid path int true "Task ID"
+	// @Success 200 {object} SuccessResponse "Task shares queried successfully."
+	// @Failure 403 {object} ErrorResponse "Only the owner can list shares"
+	// @Router /api/entry/{taskid}/shares [get]
+	protectedRoutes.GET("/entry/:taskid/shares", middleware.RequirePermission(model.PermTasksRead), controller.ListTaskSharesHandler)
+	// UnshareTaskHandler stops sharing a task with a user.
+	// @Summary Unshare Task
+	// @Description The owner can remove any collaborator; a collaborator can remove themselves. Needs an access token; API keys cannot unshare.
+	// @Security ApiKeyAuth
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param taskid path int true "Task ID"
+	// @Param id path int true "Collaborator user ID"
+	// @Success 200 {object} SuccessResponse "Task unshared successfully."
+	// @Failure 404 {object} ErrorResponse "Task is not shared with this user"
+	// @Router /api/entry/{taskid}/shares/{id} [delete]
+	protectedRoutes.DELETE("/entry/:taskid/shares/:id", middleware.RequireAccessToken(), middleware.RequirePermission(model.PermTasksWrite), controller.UnshareTaskHandler)
+
+	// GetJobHandler reports the progress of an asynchronous task request.
+	// @Summary Get Job
+	// @Description Fetch the status of a job accepted with "Prefer: respond-async" or ?async=true.
+	// @Security ApiKeyAuth
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param id path string true "Job ID"
+	// @Success 200 {object} SuccessResponse "Job queried successfully."
+	// @Failure 404 {object} ErrorResponse "Job not found"
+	// @Router /api/jobs/{id} [get]
+	protectedRoutes.GET("/jobs/:id", middleware.RequirePermission(model.PermTasksRead), controller.GetJobHandler)
+
+	// API keys are managed with an access token only, never with a key.
+	keyRoutes := protectedRoutes.Group("/keys", middleware.RequireAccessToken())
+
+	// CreateAPIKeyHandler creates a personal API key.
+	// @Summary Create API Key
+	// @Description Create a key for machine clients, sent as "Authorization: Bearer kzk_..." or X-API-Key instead of a JWT. Scopes are tasks:read and tasks:write. The key is shown only in this response.
+	// @Security ApiKeyAuth
+	// @Accept json
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param input body CreateAPIKeyInput true "Name, scopes and optional expiry"
+	// @Success 201 {object} SuccessResponse "API key created."
+	// @Failure 422 {object} ErrorResponse "Invalid scopes or expiry"
+	// @Router /api/keys [post]
+	keyRoutes.POST("", controller.CreateAPIKeyHandler)
+	// ListAPIKeysHandler lists the caller's API keys.
+	// @Summary List API Keys
+	// @Security ApiKeyAuth
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Success 200 {object} SuccessResponse "API keys, with prefix, scopes, expiry and last use."
+	// @Router /api/keys [get]
+	keyRoutes.GET("", controller.ListAPIKeysHandler)
+	// DeleteAPIKeyHandler revokes an API key.
+	// @Summary Delete API Key
+	// @Security ApiKeyAuth
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param id path int true "API key ID"
+	// @Success 200 {object} SuccessResponse "API key deleted successfully."
+	// @Failure 404 {object} ErrorResponse "API key not found"
+	// @Router /api/keys/{id} [delete]
+	keyRoutes.DELETE("/:id", controller.DeleteAPIKeyHandler)
+
+	// The account is managed with an access token only, never with a key.
+	meRoutes := protectedRoutes.Group("/me", middleware.RequireAccessToken())
+
+	// GetProfileHandler returns the caller's profile.
+	// @Summary Get Profile
+	// @Security ApiKeyAuth
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Success 200 {object} SuccessResponse "Username, display name, email, timezone, locale and roles."
+	// @Router /api/me [get]
+	meRoutes.GET("", controller.GetProfileHandler)
+	// UpdateProfileHandler changes the caller's profile.
+	// @Summary Update Profile
+	// @Description Change any of display_name, email, timezone (IANA name) and locale (language tag); fields left out are kept.
+	// @Security ApiKeyAuth
+	// @Accept json
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param input body ProfileInput true "Profile fields to change"
+	// @Success 200 {object} SuccessResponse "Profile updated successfully."
+	// @Failure 422 {object} ErrorResponse "Invalid fields"
+	// @Router /api/me [patch]
+	meRoutes.PATCH("", controller.UpdateProfileHandler)
+	// ChangeUsernameHandler renames the caller.
+	// @Summary Change Username
+	// @Description Usernames of deleted accounts stay taken. Tokens show the new username from the next login or refresh.
+	// @Security ApiKeyAuth
+	// @Accept json
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param input body ChangeUsernameInput true "New username"
+	// @Success 200 {object} SuccessResponse "Username changed successfully."
+	// @Failure 409 {object} ErrorResponse "Username already taken"
+	// @Router /api/me/username [put]
+	meRoutes.PUT("/username", controller.ChangeUsernameHandler)
+	// DeleteAccountHandler deletes the caller's account.
+	// @Summary Delete Account
+	// @Description Delete the account after confirming the password or a two-factor code, and log every session out. Depending on ACCOUNT_DELETION_MODE personal tasks are deleted with it or handed to another user. Users who logged in through an identity provider have no known password: they confirm with a two-factor code, or set a password with a reset first. Wrong confirmations count towards the login lockout.
+	// @Security ApiKeyAuth
+	// @Accept json
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param input body DeleteAccountInput true "Password or two-factor code"
+	// @Success 200 {object} SuccessResponse "Account deleted successfully."
+	// @Failure 401 {object} ErrorResponse "Password or code is incorrect"
+	// @Failure 404 {object} ErrorResponse "Two-factor authentication is not enabled"
+	// @Failure 429 {object} ErrorResponse "Too many failed attempts"
+	// @Failure 409 {object} ErrorResponse "Last owner of a workspace, or the user who inherits deleted users' tasks"
+	// @Router /api/me [delete]
+	meRoutes.DELETE("", controller.DeleteAccountHandler)
+
+	// CreateWorkspaceHandler creates a workspace owned by the caller.
+	// @Summary Create Workspace
+	// @Security ApiKeyAuth
+	// @Accept json
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param input body CreateWorkspaceInput true "Workspace name"
+	// @Success 201 {object} SuccessResponse "Workspace created successfully."
+	// @Failure 400 {object} ErrorResponse "Bad request"
+	// @Router /api/workspaces [post]
+	protectedRoutes.POST("/workspaces", middleware.RequirePermission(model.PermTasksWrite), controller.CreateWorkspaceHandler)
+	// ListWorkspacesHandler lists the workspaces of the caller.
+	// @Summary List Workspaces
+	// @Security ApiKeyAuth
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Success 200 {object} SuccessResponse "Workspaces queried successfully."
+	// @Router /api/workspaces [get]
+	protectedRoutes.GET("/workspaces", middleware.RequirePermission(model.PermTasksRead), controller.ListWorkspacesHandler)
+
+	// Routes of a single workspace; non-members get 404 as if it did not exist.
+	workspaceRoutes := protectedRoutes.Group("/workspaces/:id", middleware.ActiveWorkspace("id"))
+
+	// ListWorkspaceMembersHandler lists the members of a workspace.
+	// @Summary List Workspace Members
+	// @Security ApiKeyAuth
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param id path int true "Workspace ID"
+	// @Success 200 {object} SuccessResponse "Workspace members queried successfully."
+	// @Failure 404 {object} ErrorResponse "Workspace not found"
+	// @Router /api/workspaces/{id}/members [get]
+	workspaceRoutes.GET("/members", middleware.RequirePermission(model.PermTasksRead), controller.ListWorkspaceMembersHandler)
+	// SetWorkspaceMemberHandler adds a member or changes their role.
+	// @Summary Set Workspace Member
+	// @Description Owners add users as owner, member (read and write tasks) or viewer (read tasks). A workspace always keeps one owner. Needs an access token; API keys cannot manage members.
+	// @Security ApiKeyAuth
+	// @Accept json
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param id path int true "Workspace ID"
+	// @Param input body WorkspaceMemberInput true "User and role"
+	// @Success 200 {object} SuccessResponse "Workspace member saved successfully."
+	// @Failure 403 {object} ErrorResponse "Only owners can manage members"
+	// @Failure 404 {object} ErrorResponse "Workspace or user not found"
+	// @Failure 422 {object} ErrorResponse "Invalid role or last owner"
+	// @Router /api/workspaces/{id}/members [post]
+	workspaceRoutes.POST("/members", middleware.RequireAccessToken(), middleware.RequirePermission(model.PermTasksWrite), controller.SetWorkspaceMemberHandler)
+	// RemoveWorkspaceMemberHandler removes a member.
+	// @Summary Remove Workspace Member
+	// @Description Owners can remove anyone; other members can leave. Needs an access token; API keys cannot manage members.
+	// @Security ApiKeyAuth
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param id path int true "Workspace ID"
+	// @Param userid path int true "Member user ID"
+	// @Success 200 {object} SuccessResponse "Workspace member removed successfully."
+	// @Failure 403 {object} ErrorResponse "Only owners can remove other members"
+	// @Failure 422 {object} ErrorResponse "Last owner"
+	// @Router /api/workspaces/{id}/members/{userid} [delete]
+	workspaceRoutes.DELETE("/members/:userid", middleware.RequireAccessToken(), middleware.RequirePermission(model.PermTasksWrite), controller.RemoveWorkspaceMemberHandler)
+
+	// The task routes of /api/entry, scoped to the workspace in the path.
+	// Viewers can only read; tasks of other tenants are reported as not found.
+	// @Router /api/workspaces/{id}/entry [post]
+	workspaceRoutes.POST("/entry", middleware.RequirePermission(model.PermTasksWrite), controller.CreateTaskHandler)
+	// @Router /api/workspaces/{id}/entry [get]
+	workspaceRoutes.GET("/entry", middleware.RequirePermission(model.PermTasksRead), controller.GetTasksHandler)
+	// @Router /api/workspaces/{id}/entry/{taskid} [delete]
+	workspaceRoutes.DELETE("/entry/:taskid", middleware.RequirePermission(model.PermTasksWrite), controller.DeleteTaskHandler)
+	// @Router /api/workspaces/{id}/entry/{taskid} [put]
+	workspaceRoutes.PUT("/entry/:taskid", middleware.RequirePermission(model.PermTasksWrite), controller.UpdateTaskHandler)
+
+	adminRoutes := router.Group("/admin")
+	adminRoutes.Use(middleware.JWTAuthMiddleware())
+
+	// ListDeadLettersHandler lists jobs that failed after all retries.
+	// @Summary List Dead Letters
+	// @Security ApiKeyAuth
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param limit query int false "Page size"
+	// @Param offset query int false "Page offset"
+	// @Param include_replayed query bool false "Include dead letters that were already replayed"
+	// @Success 200 {object} SuccessResponse "Dead letters queried successfully."
+	// @Failure 403 {object} ErrorResponse "Missing permission queue:manage"
+	// @Router /admin/dead-letters [get]
+	adminRoutes.GET("/dead-letters", middleware.RequirePermission(model.PermQueueManage), controller.ListDeadLettersHandler)
+	// GetDeadLetterHandler shows a single dead letter.
+	// @Summary Get Dead Letter
+	// @Security ApiKeyAuth
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param id path int true "Dead letter ID"
+	// @Success 200 {object} SuccessResponse "Dead letter queried successfully."
+	// @Failure 404 {object} ErrorResponse "Dead letter not found"
+	// @Router /admin/dead-letters/{id} [get]
+	adminRoutes.GET("/dead-letters/:id", middleware.RequirePermission(model.PermQueueManage), controller.GetDeadLetterHandler)
+	// ReplayDeadLetterHandler queues a dead-lettered job again.
+	// @Summary Replay Dead Letter
+	// @Security ApiKeyAuth
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param id path int true "Dead letter ID"
+	// @Success 202 {object} SuccessResponse "Dead letter replayed."
+	// @Failure 404 {object} ErrorResponse "Dead letter not found"
+	// @Failure 409 {object} ErrorResponse "Dead letter already replayed"
+	// @Router /admin/dead-letters/{id}/replay [post]
+	adminRoutes.POST("/dead-letters/:id/replay", middleware.RequirePermission(model.PermQueueManage), controller.ReplayDeadLetterHandler)
+	// GetPoolHandler reports the size and load of the worker pool.
+	// @Summary Get Worker Pool
+	// @Security ApiKeyAuth
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Success 200 {object} SuccessResponse "Worker pool queried successfully."
+	// @Router /admin/pool [get]
+	adminRoutes.GET("/pool", middleware.RequirePermission(model.PermQueueManage), controller.GetPoolHandler)
+	// ResizePoolHandler changes the minimum and maximum number of workers.
+	// @Summary Resize Worker Pool
+	// @Security ApiKeyAuth
+	// @Accept json
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param input body ResizePoolInput true "New pool bounds"
+	// @Success 200 {object} SuccessResponse "Worker pool resized successfully."
+	// @Failure 422 {object} ErrorResponse "Invalid pool size"
+	// @Failure 503 {object} ErrorResponse "Worker pool is shut down"
+	// @Router /admin/pool [put]
+	adminRoutes.PUT("/pool", middleware.RequirePermission(model.PermQueueManage), controller.ResizePoolHandler)
+
+	// ListUsersHandler lists all users with their roles.
+	// @Summary List Users
+	// @Security ApiKeyAuth
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param limit query int false "Page size"
+	// @Param offset query int false "Page offset"
+	// @Success 200 {object} SuccessResponse "Users queried successfully."
+	// @Failure 403 {object} ErrorResponse "Missing permission users:read"
+	// @Router /admin/users [get]
+	adminRoutes.GET("/users", middleware.RequirePermission(model.PermUsersRead), controller.ListUsersHandler)
+	// GetUserHandler shows a user with roles and permissions.
+	// @Summary Get User
+	// @Security ApiKeyAuth
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param id path int true "User ID"
+	// @Success 200 {object} SuccessResponse "User queried successfully."
+	// @Failure 404 {object} ErrorResponse "User not found"
+	// @Router /admin/users/{id} [get]
+	adminRoutes.GET("/users/:id", middleware.RequirePermission(model.PermUsersRead), controller.GetUserHandler)
+	// SetUserRolesHandler replaces the roles of a user.
+	// @Summary Set User Roles
+	// @Description Replace the roles of a user. The change applies to tokens issued afterwards.
+	// @Security ApiKeyAuth
+	// @Accept json
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param id path int true "User ID"
+	// @Param input body SetRolesInput true "Role names"
+	// @Success 200 {object} SuccessResponse "User roles updated successfully."
+	// @Failure 404 {object} ErrorResponse "User not found"
+	// @Failure 422 {object} ErrorResponse "Unknown role"
+	// @Router /admin/users/{id}/roles [put]
+	adminRoutes.PUT("/users/:id/roles", middleware.RequirePermission(model.PermUsersWrite), controller.SetUserRolesHandler)
+	// DeleteUserHandler deletes a user and revokes their tokens.
+	// @Summary Delete User
+	// @Security ApiKeyAuth
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param id path int true "User ID"
+	// @Success 200 {object} SuccessResponse "User deleted successfully."
+	// @Failure 404 {object} ErrorResponse "User not found"
+	// @Failure 409 {object} ErrorResponse "Last owner of a workspace, or the user who inherits deleted users' tasks"
+	// @Router /admin/users/{id} [delete]
+	adminRoutes.DELETE("/users/:id", middleware.RequirePermission(model.PermUsersWrite), controller.DeleteUserHandler)
+	// ListAllTasksHandler lists the tasks of all users.
+	// @Summary List All Tasks
+	// @Security ApiKeyAuth
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param user_id query int false "Only tasks of this user"
+	// @Param limit query int false "Page size"
+	// @Param offset query int false "Page offset"
+	// @Success 200 {object} SuccessResponse "Tasks queried successfully."
+	// @Failure 403 {object} ErrorResponse "Missing permission tasks:read:any"
+	// @Router /admin/tasks [get]
+	adminRoutes.GET("/tasks", middleware.RequirePermission(model.PermTasksReadAny), controller.ListAllTasksHandler)
+	// AdminUpdateTaskHandler updates a task of any user.
+	// @Summary Update Any Task
+	// @Security ApiKeyAuth
+	// @Accept json
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param taskid path int true "Task ID to update"
+	// @Param task body Task true "Task object containing updated data"
+	// @Success 200 {object} SuccessResponse "Task updated successfully."
+	// @Failure 404 {object} ErrorResponse "Task not found"
+	// @Failure 422 {object} ErrorResponse "Invalid task or status transition"
+	// @Router /admin/tasks/{taskid} [put]
+	adminRoutes.PUT("/tasks/:taskid", middleware.RequirePermission(model.PermTasksWriteAny), controller.AdminUpdateTaskHandler)
+	// AdminDeleteTaskHandler deletes a task of any user.
+	// @Summary Delete Any Task
+	// @Security ApiKeyAuth
+	// @Produce json
+	// @Param Authorization header string true "Bearer token"
+	// @Param taskid path int true "Task ID to delete"
+	// @Success 200 {object} SuccessResponse "Task deleted successfully."
+	// @Failure 404 {object} ErrorResponse "Task not found"
+	// @Router /admin/tasks/{taskid} [delete]
+	adminRoutes.DELETE("/tasks/:taskid", middleware.RequirePermission(model.PermTasksWriteAny), controller.AdminDeleteTaskHandler)
+
+	server := &http.Server{
+		Addr:    cfg.Server.Address,
+		Handler: router,
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("failed to serve: %v", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	stop()
+
+	shutdown(server, pool)
+}
+
+// shutdown stops accepting requests, lets running handlers finish, closes the
+// queue, waits for the workers to drain it and finally closes the database
+// pool, all within the drain timeout.
+func shutdown(server *http.Server, pool *WORKER.Pool) {
+	timeout := cfg.Server.DrainTimeout
+	logf("Shutting down, draining for up to %v.", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		logf("HTTP server did not shut down cleanly: %v", err)
+	} else {
+		logf("HTTP server stopped.")
+	}
+
+	if err := pool.Shutdown(ctx); err != nil {
+		logf("Worker pool did not drain: %v", err)
+	} else {
+		logf("Worker pool drained.")
+	}
+
+	if db != nil {
+		sqlDB, err := db.DB()
+		if err == nil {
+			err = sqlDB.Close()
+		}
+		if err != nil {
+			logf("Failed to close the database pool: %v", err)
+		} else {
+			logf("Database pool closed.")
+		}
+	}
+}
 
-	router.Run(":8000")
+// logf writes to both the console and the application log.
+func logf(format string, args ...interface{}) {
+	log.Printf(format, args...)
+	logger.Printf(format, args...)
 }
@@ -1,20 +1,198 @@
 package middleware
 
 import (
+	"errors"
 	"konzek_assg/helper"
+	"konzek_assg/model"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
 
+// JWTAuthMiddleware rejects requests without a valid, unrevoked bearer token
+// or API key and stores the authenticated caller for the handlers, see
+// helper.CurrentPrincipal.
 func JWTAuthMiddleware() gin.HandlerFunc {
 	return func(context *gin.Context) {
-		err := helper.ValidateJWT(context)
+		var principal *helper.Principal
+		var err error
+		if key := helper.APIKeyFromRequest(context); key != "" {
+			principal, err = apiKeyPrincipal(key)
+		} else {
+			principal, err = tokenPrincipal(context)
+		}
+		if err != nil {
+			if isTokenError(err) {
+				context.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			} else {
+				// The denylist or the user could not be looked up; fail closed.
+				context.JSON(http.StatusServiceUnavailable, gin.H{"error": "Unable to verify token"})
+			}
+			context.Abort()
+			return
+		}
+		helper.SetPrincipal(context, principal)
+		context.Next()
+	}
+}
+
+func tokenPrincipal(context *gin.Context) (*helper.Principal, error) {
+	claims, err := helper.AuthenticateRequest(context)
+	if err != nil {
+		return nil, err
+	}
+	userID, _ := claims.UserID()
+	user, err := model.FindUserById(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	principal := &helper.Principal{
+		UserID:      user.ID,
+		Username:    user.Username,
+		Roles:       claims.Roles,
+		Permissions: claims.Permissions,
+		TokenID:     claims.ID,
+		Claims:      claims,
+	}
+	if len(principal.Roles) == 0 {
+		// Tokens issued before roles existed.
+		principal.Roles = []string{model.RoleUser}
+		principal.Permissions = model.DefaultPermissions()
+	}
+	return principal, nil
+}
+
+// apiKeyPrincipal grants the key's scopes, as far as its user still holds
+// them.
+func apiKeyPrincipal(key string) (*helper.Principal, error) {
+	apiKey, err := helper.AuthenticateAPIKey(key)
+	if err != nil {
+		return nil, err
+	}
+	user, err := model.FindUserWithRoles(apiKey.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	held := user.PermissionNames()
+	var permissions []string
+	for _, scope := range apiKey.Scopes {
+		for _, permission := range held {
+			if scope == permission {
+				permissions = append(permissions, scope)
+			}
+		}
+	}
+	return &helper.Principal{
+		UserID:      user.ID,
+		Username:    user.Username,
+		Roles:       user.RoleNames(),
+		Permissions: permissions,
+		APIKeyID:    apiKey.ID,
+	}, nil
+}
+
+func isTokenError(err error) bool {
+	return errors.Is(err, helper.ErrInvalidToken) ||
+		errors.Is(err, helper.ErrTokenExpired) ||
+		errors.Is(err, helper.ErrUnknownKey) ||
+		errors.Is(err, helper.ErrTokenRevoked) ||
+		errors.Is(err, helper.ErrInvalidAPIKey) ||
+		errors.Is(err, helper.ErrAPIKeyExpired) ||
+		errors.Is(err, model.ErrUserNotFound)
+}
+
+// RequireAccessToken refuses callers authenticated with an API key, for
+// routes that manage the account itself or who can reach its tasks: a leaked
+// key must not be able to mint more keys, change the password or let others
+// into tasks and workspaces. It must run after JWTAuthMiddleware.
+func RequireAccessToken() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		principal, err := helper.CurrentPrincipal(context)
+		if err != nil {
+			context.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			context.Abort()
+			return
+		}
+		if principal.APIKeyID != 0 {
+			context.JSON(http.StatusForbidden, gin.H{"error": "API keys cannot be used here, log in instead"})
+			context.Abort()
+			return
+		}
+		context.Next()
+	}
+}
+
+// RequirePermission only lets through callers holding every one of the
+// permissions. It must run after JWTAuthMiddleware.
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		principal, err := helper.CurrentPrincipal(context)
 		if err != nil {
 			context.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
 			context.Abort()
 			return
 		}
+		for _, permission := range permissions {
+			if !principal.HasPermission(permission) {
+				context.JSON(http.StatusForbidden, gin.H{"error": "Missing permission " + permission})
+				context.Abort()
+				return
+			}
+		}
+		context.Next()
+	}
+}
+
+// WorkspaceHeader selects the active workspace on routes outside
+// /api/workspaces/:id.
+const WorkspaceHeader = "X-Workspace-ID"
+
+// ActiveWorkspace selects the workspace the request acts on, taken from the
+// path parameter param or, if param is empty, from the optional
+// X-Workspace-ID header. The caller must be a member; the workspace and
+// their role are stored on the principal. It must run after
+// JWTAuthMiddleware.
+func ActiveWorkspace(param string) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		principal, err := helper.CurrentPrincipal(context)
+		if err != nil {
+			context.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			context.Abort()
+			return
+		}
+		value := context.GetHeader(WorkspaceHeader)
+		if param != "" {
+			value = context.Param(param)
+		}
+		if value == "" {
+			principal.WorkspaceID = 0
+			principal.WorkspaceRole = ""
+			context.Next()
+			return
+		}
+
+		workspaceID, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || workspaceID == 0 {
+			context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid workspace ID"})
+			context.Abort()
+			return
+		}
+		role, err := model.FindWorkspaceRole(uint(workspaceID), principal.UserID)
+		if err != nil {
+			if errors.Is(err, model.ErrNotWorkspaceMember) {
+				// Same answer as for a missing workspace.
+				context.JSON(http.StatusNotFound, gin.H{"error": model.ErrWorkspaceNotFound.Error()})
+			} else {
+				context.JSON(http.StatusServiceUnavailable, gin.H{"error": "Unable to verify workspace membership"})
+			}
+			context.Abort()
+			return
+		}
+		principal.WorkspaceID = uint(workspaceID)
+		principal.WorkspaceRole = role
 		context.Next()
 	}
 }
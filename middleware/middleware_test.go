@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"konzek_assg/config"
+	"konzek_assg/database"
+	"konzek_assg/helper"
+	"konzek_assg/model"
+	"testing"
+)
+
+func connectDatabase(t *testing.T) {
+	cfg, err := config.Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	database.Connect(cfg.Database)
+}
+
+func TestAPIKeyPrincipalKeepsOnlyHeldScopes(t *testing.T) {
+	connectDatabase(t)
+
+	// A role that can read tasks but not write them.
+	read := model.Permission{Name: model.PermTasksRead}
+	if err := database.Database.Where(read).FirstOrCreate(&read).Error; err != nil {
+		t.Fatal(err)
+	}
+	role := model.Role{Name: "api-key-reader", Permissions: []model.Permission{read}}
+	if err := database.Database.Create(&role).Error; err != nil {
+		t.Fatal(err)
+	}
+	defer database.Database.Delete(&role)
+	defer database.Database.Exec("DELETE FROM role_permissions WHERE role_id = ?", role.ID)
+
+	user := model.User{Username: "api-key-reader", Password: "Reader-pass-12", Roles: []model.Role{role}}
+	if err := database.Database.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	defer database.Database.Unscoped().Delete(&user)
+	defer database.Database.Exec("DELETE FROM user_roles WHERE user_id = ?", user.ID)
+
+	key, id, hash, err := helper.NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := model.APIKey{UserID: user.ID, Name: "test", Prefix: id, KeyHash: hash, Scopes: model.ScopeList{model.PermTasksRead, model.PermTasksWrite}}
+	if err := model.CreateAPIKey(&record); err != nil {
+		t.Fatal(err)
+	}
+	defer database.Database.Delete(&record)
+
+	principal, err := apiKeyPrincipal(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal.APIKeyID != record.ID || principal.UserID != user.ID {
+		t.Errorf("principal of key %d and user %d, want %d and %d", principal.APIKeyID, principal.UserID, record.ID, user.ID)
+	}
+	if len(principal.Permissions) != 1 || principal.Permissions[0] != model.PermTasksRead {
+		t.Errorf("permissions = %v, want only %s", principal.Permissions, model.PermTasksRead)
+	}
+}
@@ -0,0 +1,127 @@
+package model
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"konzek_assg/database"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAPIKeyNotFound     = errors.New("api key not found")
+	ErrInvalidAPIKeyScope = errors.New("api key scopes must be tasks:read or tasks:write")
+)
+
+// APIKeyScopes are the permissions an API key can be given. A key never
+// grants more than its user currently has.
+var APIKeyScopes = []string{PermTasksRead, PermTasksWrite}
+
+// ScopeList is stored as a comma-separated column.
+type ScopeList []string
+
+func (s ScopeList) Value() (driver.Value, error) {
+	return strings.Join(s, ","), nil
+}
+
+func (s *ScopeList) Scan(value interface{}) error {
+	var raw string
+	switch v := value.(type) {
+	case string:
+		raw = v
+	case []byte:
+		raw = string(v)
+	case nil:
+	default:
+		return fmt.Errorf("scope list: unexpected %T", value)
+	}
+	*s = nil
+	if raw != "" {
+		*s = strings.Split(raw, ",")
+	}
+	return nil
+}
+
+// APIKey lets machine clients call the API as their user without a
+// password. Prefix identifies the key and is shown in listings; the rest of
+// the key is only known by its hash.
+type APIKey struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"not null;index" json:"userid"`
+	Name       string     `gorm:"size:255;not null" json:"name"`
+	Prefix     string     `gorm:"size:32;not null;uniqueIndex" json:"prefix"`
+	KeyHash    string     `gorm:"size:64;not null" json:"-"`
+	Scopes     ScopeList  `gorm:"type:text;not null" json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func init() {
+	database.RegisterModels(&APIKey{})
+}
+
+// ValidAPIKeyScopes reports whether every scope is one of APIKeyScopes.
+func ValidAPIKeyScopes(scopes []string) bool {
+	if len(scopes) == 0 {
+		return false
+	}
+	for _, scope := range scopes {
+		valid := false
+		for _, allowed := range APIKeyScopes {
+			if scope == allowed {
+				valid = true
+			}
+		}
+		if !valid {
+			return false
+		}
+	}
+	return true
+}
+
+func CreateAPIKey(key *APIKey) error {
+	if !ValidAPIKeyScopes(key.Scopes) {
+		return ErrInvalidAPIKeyScope
+	}
+	key.Scopes = uniqueStrings(key.Scopes)
+	return database.Database.Create(key).Error
+}
+
+func ListAPIKeys(userID uint) ([]APIKey, error) {
+	var keys []APIKey
+	err := database.Database.Where("user_id = ?", userID).Order("id").Find(&keys).Error
+	return keys, err
+}
+
+func FindAPIKeyByPrefix(prefix string) (APIKey, error) {
+	var key APIKey
+	err := database.Database.Where("prefix = ?", prefix).First(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+	return key, err
+}
+
+// DeleteAPIKey deletes a key of the user.
+func DeleteAPIKey(userID, id uint) error {
+	result := database.Database.Where("id = ? AND user_id = ?", id, userID).Delete(&APIKey{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// TouchAPIKey records that the key was used at now. To spare a write per
+// request it is only recorded once a minute.
+func TouchAPIKey(id uint, now time.Time) error {
+	return database.Database.Model(&APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, now.Add(-time.Minute)).
+		Update("last_used_at", now).Error
+}
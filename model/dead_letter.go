@@ -0,0 +1,75 @@
+package model
+
+import (
+	"errors"
+	"konzek_assg/database"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetter is a job that kept failing after all of its retries.
+type DeadLetter struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	JobID         string     `gorm:"size:64;not null;index" json:"job_id"`
+	Operation     string     `gorm:"size:32;not null" json:"operation"`
+	Payload       string     `gorm:"type:text;not null" json:"payload"`
+	RequesterID   uint       `gorm:"not null;index" json:"requester_id"`
+	CorrelationID string     `gorm:"size:64" json:"correlation_id"`
+	Track         bool       `gorm:"not null;default:false" json:"track"`
+	AnyOwner      bool       `gorm:"not null;default:false" json:"any_owner"`
+	Attempts      int        `gorm:"not null" json:"attempts"`
+	LastError     string     `gorm:"size:1024" json:"last_error"`
+	ReplayedAt    *time.Time `json:"replayed_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+func init() {
+	database.RegisterModels(&DeadLetter{})
+}
+
+func CreateDeadLetter(deadLetter *DeadLetter) error {
+	return database.Database.Create(deadLetter).Error
+}
+
+// ListDeadLetters returns dead letters newest first. Replayed ones are only
+// included when includeReplayed is set.
+func ListDeadLetters(includeReplayed bool, limit, offset int) ([]DeadLetter, error) {
+	var deadLetters []DeadLetter
+	query := database.Database.Order("id DESC").Limit(limit).Offset(offset)
+	if !includeReplayed {
+		query = query.Where("replayed_at IS NULL")
+	}
+	if err := query.Find(&deadLetters).Error; err != nil {
+		return nil, err
+	}
+	return deadLetters, nil
+}
+
+func FindDeadLetter(id uint) (DeadLetter, error) {
+	var deadLetter DeadLetter
+	if err := database.Database.First(&deadLetter, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return DeadLetter{}, ErrDeadLetterNotFound
+		}
+		return DeadLetter{}, err
+	}
+	return deadLetter, nil
+}
+
+// ClaimDeadLetterReplay marks the dead letter as replayed unless it already
+// is, and reports whether this call claimed it. Only the claimant replays the
+// job, so concurrent replays cannot run it twice.
+func ClaimDeadLetterReplay(id uint) (bool, error) {
+	result := database.Database.Model(&DeadLetter{}).
+		Where("id = ? AND replayed_at IS NULL", id).
+		Update("replayed_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+// ReleaseDeadLetterReplay undoes a claim whose replay could not be queued.
+func ReleaseDeadLetterReplay(id uint) error {
+	return database.Database.Model(&DeadLetter{}).Where("id = ?", id).Update("replayed_at", nil).Error
+}
@@ -0,0 +1,74 @@
+package model
+
+import (
+	"errors"
+	"konzek_assg/database"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// JobStatus is the processing state of an asynchronous job.
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+// JobRecord tracks an asynchronous task mutation so clients can poll it.
+type JobRecord struct {
+	ID        string    `gorm:"primaryKey;size:64" json:"id"`
+	UserID    uint      `gorm:"not null;index" json:"userid"`
+	Operation string    `gorm:"size:32;not null" json:"operation"`
+	TaskID    uint      `json:"taskid"`
+	Status    JobStatus `gorm:"size:32;not null" json:"status"`
+	Error     string    `gorm:"size:1024" json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (JobRecord) TableName() string {
+	return "jobs"
+}
+
+func init() {
+	database.RegisterModels(&JobRecord{})
+}
+
+func CreateJobRecord(record *JobRecord) error {
+	if record.Status == "" {
+		record.Status = JobQueued
+	}
+	return database.Database.Create(record).Error
+}
+
+// UpdateJobRecord moves the job to status, recording the task it touched and
+// the error message of a failed run.
+func UpdateJobRecord(id string, status JobStatus, taskID uint, errMessage string) error {
+	updates := map[string]interface{}{
+		"status": status,
+		"error":  errMessage,
+	}
+	if taskID != 0 {
+		updates["task_id"] = taskID
+	}
+	return database.Database.Model(&JobRecord{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// FindJobRecordForUser returns the job only if it belongs to userID.
+func FindJobRecordForUser(id string, userID uint) (JobRecord, error) {
+	var record JobRecord
+	err := database.Database.Where("id = ? AND user_id = ?", id, userID).First(&record).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return JobRecord{}, ErrJobNotFound
+		}
+		return JobRecord{}, err
+	}
+	return record, nil
+}
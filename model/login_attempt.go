@@ -0,0 +1,97 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"konzek_assg/database"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Reasons a login attempt failed.
+const (
+	LoginUnknownUser   = "unknown_user"
+	LoginWrongPassword = "wrong_password"
+	LoginWrongMFACode  = "wrong_mfa_code"
+	LoginLockedOut     = "locked_out"
+)
+
+// LoginAttempt is the audit record of a failed login. UserID is 0 when the
+// username does not exist.
+type LoginAttempt struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Username  string    `gorm:"size:255;not null;index" json:"username"`
+	UserID    uint      `gorm:"not null;index" json:"userid"`
+	IP        string    `gorm:"size:64;not null;index" json:"ip"`
+	UserAgent string    `gorm:"size:255" json:"user_agent"`
+	Reason    string    `gorm:"size:32;not null" json:"reason"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// LoginThrottle counts the recent failed logins of one user or client IP, see package lockout.
+type LoginThrottle struct {
+	Key           string `gorm:"primaryKey;size:320"`
+	Failures      int    `gorm:"not null"`
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+}
+
+func init() {
+	database.RegisterModels(&LoginAttempt{}, &LoginThrottle{})
+}
+
+func CreateLoginAttempt(attempt *LoginAttempt) error {
+	return database.Database.Create(attempt).Error
+}
+
+// FindLoginThrottle returns the throttle stored under key, or a fresh one
+// without failures.
+func FindLoginThrottle(key string) (LoginThrottle, error) {
+	var throttle LoginThrottle
+	err := database.Database.Where("key = ?", key).First(&throttle).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return LoginThrottle{Key: key}, nil
+	}
+	return throttle, err
+}
+
+// UpdateLoginThrottles applies update to the throttles stored under keys, in
+// the order of keys, while holding all of their row locks. The rows are
+// locked in key order, so concurrent calls cannot deadlock.
+func UpdateLoginThrottles(keys []string, update func([]*LoginThrottle)) error {
+	return database.Database.Transaction(func(tx *gorm.DB) error {
+		for _, key := range keys {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&LoginThrottle{Key: key}).Error; err != nil {
+				return err
+			}
+		}
+		var locked []LoginThrottle
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key IN ?", keys).Order("key").Find(&locked).Error; err != nil {
+			return err
+		}
+		byKey := make(map[string]*LoginThrottle, len(locked))
+		for i := range locked {
+			byKey[locked[i].Key] = &locked[i]
+		}
+		throttles := make([]*LoginThrottle, len(keys))
+		for i, key := range keys {
+			if byKey[key] == nil {
+				return fmt.Errorf("login throttle %q vanished while locked", key)
+			}
+			throttles[i] = byKey[key]
+		}
+		update(throttles)
+		for _, throttle := range locked {
+			if err := tx.Save(&throttle).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func DeleteLoginThrottle(key string) error {
+	return database.Database.Where("key = ?", key).Delete(&LoginThrottle{}).Error
+}
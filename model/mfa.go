@@ -0,0 +1,138 @@
+package model
+
+import (
+	"errors"
+	"konzek_assg/database"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrMFANotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnrolled    = errors.New("no two-factor enrollment to confirm")
+)
+
+// UserMFA is the TOTP secret of a user. It only counts once ConfirmedAt is
+// set, that is after the user proved their authenticator app has it.
+// LastUsedStep is the period of the last accepted code, so it can not be
+// replayed.
+type UserMFA struct {
+	UserID       uint   `gorm:"primaryKey;autoIncrement:false"`
+	Secret       string `gorm:"size:64;not null"`
+	ConfirmedAt  *time.Time
+	LastUsedStep int64 `gorm:"not null;default:0"`
+	CreatedAt    time.Time
+}
+
+// RecoveryCode is a one-time code that replaces a TOTP code when the
+// authenticator is lost, stored by its hash.
+type RecoveryCode struct {
+	ID       uint   `gorm:"primaryKey"`
+	UserID   uint   `gorm:"not null;index"`
+	CodeHash string `gorm:"size:64;not null;uniqueIndex"`
+	UsedAt   *time.Time
+}
+
+func init() {
+	database.RegisterModels(&UserMFA{}, &RecoveryCode{})
+}
+
+// FindMFA returns the confirmed TOTP settings of the user.
+func FindMFA(userID uint) (UserMFA, error) {
+	var mfa UserMFA
+	err := database.Database.Where("user_id = ? AND confirmed_at IS NOT NULL", userID).First(&mfa).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return UserMFA{}, ErrMFANotEnabled
+	}
+	return mfa, err
+}
+
+// MFAEnabled reports whether logins of the user need a second factor.
+func MFAEnabled(userID uint) (bool, error) {
+	_, err := FindMFA(userID)
+	if errors.Is(err, ErrMFANotEnabled) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// StartMFAEnrollment stores a new, unconfirmed secret for the user,
+// replacing an earlier unconfirmed one.
+func StartMFAEnrollment(userID uint, secret string) error {
+	return database.Database.Transaction(func(tx *gorm.DB) error {
+		var existing UserMFA
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).First(&existing).Error
+		switch {
+		case err == nil && existing.ConfirmedAt != nil:
+			return ErrMFAAlreadyEnabled
+		case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+			return err
+		}
+		return tx.Save(&UserMFA{UserID: userID, Secret: secret}).Error
+	})
+}
+
+// FindMFAEnrollment returns the unconfirmed secret of the user.
+func FindMFAEnrollment(userID uint) (UserMFA, error) {
+	var mfa UserMFA
+	err := database.Database.Where("user_id = ? AND confirmed_at IS NULL", userID).First(&mfa).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return UserMFA{}, ErrMFANotEnrolled
+	}
+	return mfa, err
+}
+
+// ConfirmMFA enables the pending enrollment, recording step as used, and
+// replaces the user's recovery codes with codeHashes.
+func ConfirmMFA(userID uint, step int64, codeHashes []string) error {
+	return database.Database.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&UserMFA{}).
+			Where("user_id = ? AND confirmed_at IS NULL", userID).
+			Updates(map[string]interface{}{"confirmed_at": time.Now(), "last_used_step": step})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrMFANotEnrolled
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		codes := make([]RecoveryCode, len(codeHashes))
+		for i, hash := range codeHashes {
+			codes[i] = RecoveryCode{UserID: userID, CodeHash: hash}
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// UseTOTPStep records that the code of step was used. It reports false if
+// that or a later code was used before, so each code works only once.
+func UseTOTPStep(userID uint, step int64) (bool, error) {
+	result := database.Database.Model(&UserMFA{}).
+		Where("user_id = ? AND last_used_step < ?", userID, step).
+		Update("last_used_step", step)
+	return result.RowsAffected == 1, result.Error
+}
+
+// UseRecoveryCode spends the recovery code stored under hash. It reports
+// false if the user has no such unused code.
+func UseRecoveryCode(userID uint, hash string) (bool, error) {
+	result := database.Database.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+// DisableMFA removes the TOTP secret and recovery codes of the user.
+func DisableMFA(userID uint) error {
+	return database.Database.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&UserMFA{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+	})
+}
@@ -0,0 +1,102 @@
+package model
+
+import (
+	"errors"
+	"konzek_assg/database"
+	"konzek_assg/password"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrResetTokenInvalid = errors.New("invalid or expired reset token")
+
+// PasswordResetToken is a single-use password reset token, stored by its
+// hash like RefreshToken.
+type PasswordResetToken struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"not null;index"`
+	TokenHash string    `gorm:"size:64;not null;uniqueIndex"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+func init() {
+	database.RegisterModels(&PasswordResetToken{})
+}
+
+// CreatePasswordResetToken stores the token, dropping the unused tokens the
+// user asked for before so only the latest one works.
+func CreatePasswordResetToken(token *PasswordResetToken) error {
+	return database.Database.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND used_at IS NULL", token.UserID).Delete(&PasswordResetToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+}
+
+func findUsableResetToken(tx *gorm.DB, hash string) (PasswordResetToken, error) {
+	var token PasswordResetToken
+	err := tx.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hash, time.Now()).First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return PasswordResetToken{}, ErrResetTokenInvalid
+	}
+	return token, err
+}
+
+// FindPasswordResetUser returns the user the token stored under hash resets
+// the password of, without spending it.
+func FindPasswordResetUser(hash string) (User, error) {
+	token, err := findUsableResetToken(database.Database, hash)
+	if err != nil {
+		return User{}, err
+	}
+	user, err := FindUserById(token.UserID)
+	if errors.Is(err, ErrUserNotFound) {
+		return User{}, ErrResetTokenInvalid
+	}
+	return user, err
+}
+
+// ResetPassword spends the token stored under hash and sets the password of
+// its user to plain.
+func ResetPassword(hash, plain string) (User, error) {
+	var user User
+	err := database.Database.Transaction(func(tx *gorm.DB) error {
+		token, err := findUsableResetToken(tx.Clauses(clause.Locking{Strength: "UPDATE"}), hash)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&token).Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&user, token.UserID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrResetTokenInvalid
+			}
+			return err
+		}
+		return setPassword(tx, &user, plain)
+	})
+	return user, err
+}
+
+// ChangePassword stores a hash of plain as the user's password.
+func (user *User) ChangePassword(plain string) error {
+	return setPassword(database.Database, user, plain)
+}
+
+func setPassword(tx *gorm.DB, user *User, plain string) error {
+	hash, err := password.Hash(plain)
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(user).Update("password", hash).Error; err != nil {
+		return err
+	}
+	user.Password = hash
+	return nil
+}
@@ -0,0 +1,213 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"konzek_assg/database"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidProfile  = errors.New("invalid profile")
+	ErrUsernameTaken   = errors.New("username is already taken")
+	ErrInvalidUsername = errors.New("username must be 1 to 255 characters without surrounding spaces")
+	ErrDeleteHeir      = errors.New("user inherits the tasks of deleted users and cannot be deleted")
+	ErrHeirNotFound    = errors.New("user to reassign the tasks of deleted users to does not exist")
+)
+
+// What happens to the tasks of a deleted user, see DeletionPolicy.
+const (
+	DeleteTasksCascade  = "cascade"
+	DeleteTasksReassign = "reassign"
+)
+
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}([-_][A-Za-z0-9]{2,8})*$`)
+
+// ProfileInput changes the fields of a profile that are set; the others are
+// left alone.
+type ProfileInput struct {
+	DisplayName *string `json:"display_name"`
+	Email       *string `json:"email"`
+	Timezone    *string `json:"timezone"`
+	Locale      *string `json:"locale"`
+}
+
+// Validate reports every invalid field at once. An empty email clears it.
+func (input ProfileInput) Validate() error {
+	var problems []string
+	if input.DisplayName != nil && len(*input.DisplayName) > 255 {
+		problems = append(problems, "display_name must be at most 255 characters")
+	}
+	if input.Email != nil && *input.Email != "" {
+		address, err := mail.ParseAddress(*input.Email)
+		if err != nil || address.Address != *input.Email || len(*input.Email) > 255 {
+			problems = append(problems, "email must be a plain email address")
+		}
+	}
+	if input.Timezone != nil {
+		if _, err := time.LoadLocation(*input.Timezone); err != nil || *input.Timezone == "" || *input.Timezone == "Local" {
+			problems = append(problems, "timezone must be an IANA time zone such as Europe/Istanbul")
+		}
+	}
+	if input.Locale != nil && (!localePattern.MatchString(*input.Locale) || len(*input.Locale) > 35) {
+		problems = append(problems, "locale must be a language tag such as en or tr-TR")
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrInvalidProfile, strings.Join(problems, "; "))
+}
+
+// UpdateProfile applies input, which must be valid, to the user's profile
+// and returns the updated user.
+func UpdateProfile(userID uint, input ProfileInput) (User, error) {
+	updates := map[string]interface{}{}
+	if input.DisplayName != nil {
+		updates["display_name"] = strings.TrimSpace(*input.DisplayName)
+	}
+	if input.Email != nil {
+		updates["email"] = *input.Email
+	}
+	if input.Timezone != nil {
+		updates["timezone"] = *input.Timezone
+	}
+	if input.Locale != nil {
+		updates["locale"] = strings.ReplaceAll(*input.Locale, "_", "-")
+	}
+	if len(updates) > 0 {
+		result := database.Database.Model(&User{}).Where("id = ?", userID).Updates(updates)
+		if result.Error != nil {
+			return User{}, result.Error
+		}
+		if result.RowsAffected == 0 {
+			return User{}, ErrUserNotFound
+		}
+	}
+	return FindUserWithRoles(userID)
+}
+
+// ChangeUsername renames the user. Names of deleted users stay taken, so
+// nobody can pass for them.
+func ChangeUsername(userID uint, username string) error {
+	if username == "" || username != strings.TrimSpace(username) || len(username) > 255 {
+		return ErrInvalidUsername
+	}
+	return database.Database.Transaction(func(tx *gorm.DB) error {
+		var taken int64
+		if err := tx.Unscoped().Model(&User{}).Where("username = ? AND id <> ?", username, userID).Count(&taken).Error; err != nil {
+			return err
+		}
+		if taken > 0 {
+			return ErrUsernameTaken
+		}
+		result := tx.Model(&User{}).Where("id = ?", userID).Update("username", username)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrUserNotFound
+		}
+		return nil
+	})
+}
+
+// DeletionPolicy decides what happens to the tasks of a deleted user. With
+// DeleteTasksCascade their personal tasks are soft-deleted along with them;
+// workspace tasks belong to the workspace and stay. With DeleteTasksReassign
+// every task they own is handed to the user ReassignTo. Either way no task is
+// left pointing at a user that is gone, which the tasks' OnDelete:SET NULL
+// constraint could not handle as user_id is not nullable.
+type DeletionPolicy struct {
+	Mode       string
+	ReassignTo uint
+}
+
+// Check reports whether users can be deleted following the policy, i.e. the
+// user who inherits their tasks exists. It is meant to run at startup.
+func (policy DeletionPolicy) Check() error {
+	if policy.Mode != DeleteTasksReassign {
+		return nil
+	}
+	return findHeir(database.Database, policy.ReassignTo, &User{})
+}
+
+func findHeir(tx *gorm.DB, id uint, heir *User) error {
+	err := tx.First(heir, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("%w: user %d", ErrHeirNotFound, id)
+	}
+	return err
+}
+
+// DeleteUser soft-deletes the user following policy and removes what only
+// made sense for them: shares to them, workspace memberships, API keys,
+// linked identities, two-factor and reset secrets. A user who is the last
+// owner of a workspace cannot be deleted, nor can the user who inherits the
+// tasks of deleted users. Sessions are revoked by the caller.
+func DeleteUser(id uint, policy DeletionPolicy) error {
+	return database.Database.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+
+		var owned []WorkspaceMembership
+		if err := tx.Where("user_id = ? AND role = ?", id, WorkspaceOwner).Find(&owned).Error; err != nil {
+			return err
+		}
+		for _, membership := range owned {
+			if err := ensureAnotherOwner(tx, membership.WorkspaceID, id); err != nil {
+				return err
+			}
+		}
+
+		if err := deleteUserTasks(tx, id, policy); err != nil {
+			return err
+		}
+		for _, related := range []interface{}{&TaskShare{}, &WorkspaceMembership{}, &APIKey{}, &UserIdentity{}, &UserMFA{}, &RecoveryCode{}, &PasswordResetToken{}} {
+			if err := tx.Where("user_id = ?", id).Delete(related).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&user).Association("Roles").Clear(); err != nil {
+			return err
+		}
+		return tx.Delete(&user).Error
+	})
+}
+
+func deleteUserTasks(tx *gorm.DB, id uint, policy DeletionPolicy) error {
+	switch policy.Mode {
+	case DeleteTasksCascade:
+		personal := tx.Model(&Task{}).Select("id").Where("user_id = ? AND workspace_id = 0", id)
+		if err := tx.Where("task_id IN (?)", personal).Delete(&TaskShare{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ? AND workspace_id = 0", id).Delete(&Task{}).Error
+	case DeleteTasksReassign:
+		if policy.ReassignTo == id {
+			return ErrDeleteHeir
+		}
+		var heir User
+		if err := findHeir(tx, policy.ReassignTo, &heir); err != nil {
+			return err
+		}
+		owned := tx.Model(&Task{}).Select("id").Where("user_id = ?", id)
+		// The new owner does not need a share of their own task.
+		if err := tx.Where("user_id = ? AND task_id IN (?)", heir.ID, owned).Delete(&TaskShare{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Task{}).Where("user_id = ?", id).Update("user_id", heir.ID).Error
+	default:
+		return fmt.Errorf("unknown account deletion mode %q", policy.Mode)
+	}
+}
@@ -0,0 +1,26 @@
+package model
+
+import (
+	"konzek_assg/database"
+	"time"
+)
+
+// QueuedJob is a job waiting in, or being processed from, the Postgres-backed
+// worker queue.
+type QueuedJob struct {
+	ID            string     `gorm:"primaryKey;size:64"`
+	Operation     string     `gorm:"size:32;not null"`
+	Payload       string     `gorm:"type:text;not null"`
+	RequesterID   uint       `gorm:"not null;index"`
+	CorrelationID string     `gorm:"size:64"`
+	Track         bool       `gorm:"not null;default:false"`
+	AnyOwner      bool       `gorm:"not null;default:false"`
+	Attempts      int        `gorm:"not null;default:0"`
+	AvailableAt   time.Time  `gorm:"not null;index"`
+	LockedUntil   *time.Time `gorm:"index"`
+	CreatedAt     time.Time
+}
+
+func init() {
+	database.RegisterModels(&QueuedJob{})
+}
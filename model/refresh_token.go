@@ -0,0 +1,104 @@
+package model
+
+import (
+	"errors"
+	"konzek_assg/database"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+	ErrRefreshTokenExpired = errors.New("refresh token has expired")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used")
+)
+
+// RefreshToken is one opaque refresh token, stored by its hash. Every login
+// starts a family; each rotation marks the presented token used and adds its
+// successor to the same family.
+type RefreshToken struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"not null;index"`
+	FamilyID  string    `gorm:"size:64;not null;index"`
+	TokenHash string    `gorm:"size:64;not null;uniqueIndex"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+func init() {
+	database.RegisterModels(&RefreshToken{})
+}
+
+func CreateRefreshToken(token *RefreshToken) error {
+	return database.Database.Create(token).Error
+}
+
+// RotateRefreshToken exchanges the token stored under hash for next, which
+// joins the same family. Presenting a token that was already used means it
+// leaked, so its whole family is revoked and ErrRefreshTokenReused returned.
+func RotateRefreshToken(hash string, next *RefreshToken) error {
+	var reused bool
+	err := database.Database.Transaction(func(tx *gorm.DB) error {
+		var current RefreshToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("token_hash = ?", hash).First(&current).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrRefreshTokenInvalid
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		switch {
+		case current.RevokedAt != nil:
+			return ErrRefreshTokenInvalid
+		case current.UsedAt != nil:
+			reused = true
+			return revokeRefreshTokenFamily(tx, current.FamilyID, now)
+		case !now.Before(current.ExpiresAt):
+			return ErrRefreshTokenExpired
+		}
+
+		if err := tx.Model(&current).Update("used_at", now).Error; err != nil {
+			return err
+		}
+		next.UserID = current.UserID
+		next.FamilyID = current.FamilyID
+		return tx.Create(next).Error
+	})
+	if err == nil && reused {
+		return ErrRefreshTokenReused
+	}
+	return err
+}
+
+func revokeRefreshTokenFamily(tx *gorm.DB, familyID string, now time.Time) error {
+	return tx.Model(&RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", now).Error
+}
+
+// RevokeRefreshTokenFamilyOf revokes the family of the token stored under
+// hash. Unknown tokens are ignored.
+func RevokeRefreshTokenFamilyOf(hash string) error {
+	var token RefreshToken
+	err := database.Database.Where("token_hash = ?", hash).First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return revokeRefreshTokenFamily(database.Database, token.FamilyID, time.Now())
+}
+
+// RevokeRefreshTokensForUser revokes every refresh token of the user.
+func RevokeRefreshTokensForUser(userID uint) error {
+	return database.Database.Model(&RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
@@ -0,0 +1,26 @@
+package model
+
+import (
+	"konzek_assg/database"
+	"time"
+)
+
+// RevokedToken is an access token revoked before its expiry, identified by
+// its jti claim. The row can be dropped once the token has expired.
+type RevokedToken struct {
+	JTI       string    `gorm:"primaryKey;size:64"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+	CreatedAt time.Time
+}
+
+// UserRevocation revokes every access token of a user issued up to
+// RevokedBefore, as done by "log out all sessions".
+type UserRevocation struct {
+	UserID        uint      `gorm:"primaryKey;autoIncrement:false"`
+	RevokedBefore time.Time `gorm:"not null"`
+	ExpiresAt     time.Time `gorm:"not null;index"`
+}
+
+func init() {
+	database.RegisterModels(&RevokedToken{}, &UserRevocation{})
+}
@@ -0,0 +1,172 @@
+package model
+
+import (
+	"errors"
+	"konzek_assg/database"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Permissions checked by the HTTP layer. The ":any" variants extend the
+// plain ones to tasks of other users.
+const (
+	PermTasksRead     = "tasks:read"
+	PermTasksWrite    = "tasks:write"
+	PermTasksReadAny  = "tasks:read:any"
+	PermTasksWriteAny = "tasks:write:any"
+	PermUsersRead     = "users:read"
+	PermUsersWrite    = "users:write"
+	PermQueueManage   = "queue:manage"
+)
+
+var ErrUnknownRole = errors.New("unknown role")
+
+// builtinRoles are created by SeedRoles. Users without any role row act as
+// RoleUser.
+var builtinRoles = map[string][]string{
+	RoleUser: {PermTasksRead, PermTasksWrite},
+	RoleAdmin: {
+		PermTasksRead, PermTasksWrite, PermTasksReadAny, PermTasksWriteAny,
+		PermUsersRead, PermUsersWrite, PermQueueManage,
+	},
+}
+
+type Permission struct {
+	ID   uint   `gorm:"primaryKey" json:"-"`
+	Name string `gorm:"size:64;not null;uniqueIndex" json:"name"`
+}
+
+type Role struct {
+	ID          uint         `gorm:"primaryKey" json:"-"`
+	Name        string       `gorm:"size:64;not null;uniqueIndex" json:"name"`
+	Permissions []Permission `gorm:"many2many:role_permissions;" json:"permissions"`
+}
+
+func init() {
+	// User is registered again here so its user_roles join table is created.
+	database.RegisterModels(&Permission{}, &Role{}, &User{})
+}
+
+// SeedRoles makes sure the built-in roles exist with at least their built-in
+// permissions. Permissions granted to them by hand are kept.
+func SeedRoles() error {
+	return database.Database.Transaction(func(tx *gorm.DB) error {
+		for name, permissions := range builtinRoles {
+			role := Role{Name: name}
+			if err := tx.Where(Role{Name: name}).FirstOrCreate(&role).Error; err != nil {
+				return err
+			}
+			for _, permName := range permissions {
+				permission := Permission{Name: permName}
+				if err := tx.Where(Permission{Name: permName}).FirstOrCreate(&permission).Error; err != nil {
+					return err
+				}
+				if err := tx.Model(&role).Association("Permissions").Append(&permission); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// FindRoles loads the named roles, failing with ErrUnknownRole if one does
+// not exist.
+func FindRoles(names []string) ([]Role, error) {
+	var roles []Role
+	if len(names) == 0 {
+		return roles, nil
+	}
+	if err := database.Database.Where("name IN ?", names).Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	if len(roles) != len(uniqueStrings(names)) {
+		return nil, ErrUnknownRole
+	}
+	return roles, nil
+}
+
+// SetUserRoles replaces the roles of the user.
+func SetUserRoles(userID uint, names []string) error {
+	roles, err := FindRoles(names)
+	if err != nil {
+		return err
+	}
+	user := User{}
+	user.ID = userID
+	return database.Database.Model(&user).Association("Roles").Replace(roles)
+}
+
+// GrantRole adds the role to each of the users, as done at startup for the
+// configured admin user IDs. Unknown users are skipped.
+func GrantRole(name string, userIDs []uint) error {
+	roles, err := FindRoles([]string{name})
+	if err != nil {
+		return err
+	}
+	for _, id := range userIDs {
+		user := User{}
+		if err := database.Database.First(&user, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			return err
+		}
+		if err := database.Database.Model(&user).Association("Roles").Append(roles); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RoleNames lists the user's roles; Roles must have been loaded.
+func (user *User) RoleNames() []string {
+	if len(user.Roles) == 0 {
+		return []string{RoleUser}
+	}
+	names := make([]string, 0, len(user.Roles))
+	for _, role := range user.Roles {
+		names = append(names, role.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PermissionNames lists the permissions granted by the user's roles;
+// Roles.Permissions must have been loaded.
+func (user *User) PermissionNames() []string {
+	if len(user.Roles) == 0 {
+		return DefaultPermissions()
+	}
+	var names []string
+	for _, role := range user.Roles {
+		for _, permission := range role.Permissions {
+			names = append(names, permission.Name)
+		}
+	}
+	return uniqueStrings(names)
+}
+
+// DefaultPermissions are the permissions of a user without any role row.
+func DefaultPermissions() []string {
+	return append([]string(nil), builtinRoles[RoleUser]...)
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := make([]string, 0, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
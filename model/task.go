@@ -19,36 +19,99 @@ type ErrorResponse struct {
 	Message    string `json:"error"`
 }
 
+// TaskStatus is the lifecycle state of a task.
+type TaskStatus string
+
+const (
+	StatusTodo       TaskStatus = "todo"
+	StatusInProgress TaskStatus = "in_progress"
+	StatusBlocked    TaskStatus = "blocked"
+	StatusDone       TaskStatus = "done"
+	StatusArchived   TaskStatus = "archived"
+)
+
+var (
+	ErrInvalidStatus     = errors.New("invalid task status")
+	ErrInvalidTransition = errors.New("task status transition is not allowed")
+)
+
+var allowedTransitions = map[TaskStatus][]TaskStatus{
+	StatusTodo:       {StatusInProgress, StatusBlocked, StatusDone, StatusArchived},
+	StatusInProgress: {StatusTodo, StatusBlocked, StatusDone, StatusArchived},
+	StatusBlocked:    {StatusTodo, StatusInProgress, StatusArchived},
+	StatusDone:       {StatusInProgress, StatusArchived},
+	StatusArchived:   {StatusTodo},
+}
+
+func (s TaskStatus) Valid() bool {
+	_, ok := allowedTransitions[s]
+	return ok
+}
+
+// CanTransitionTo reports whether a task in status s may move to next.
+// Rows written before statuses were validated carry an unknown status and
+// may move to any valid one.
+func (s TaskStatus) CanTransitionTo(next TaskStatus) bool {
+	if !next.Valid() {
+		return false
+	}
+	if s == next || !s.Valid() {
+		return true
+	}
+	for _, allowed := range allowedTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// Task belongs to the workspace WorkspaceID, or is a personal task of UserID
+// when WorkspaceID is 0.
 type Task struct {
 	gorm.Model
-	UserID      uint   `gorm:"size:255;not null;" json:"userid"`
-	Title       string `gorm:"size:255;not null;" json:"title"`
-	Description string `gorm:"size:255;not null;" json:"description"`
-	Status      string `gorm:"size:255;not null;" json:"status"`
+	UserID      uint       `gorm:"size:255;not null;" json:"userid"`
+	WorkspaceID uint       `gorm:"not null;default:0;index" json:"workspace_id"`
+	Title       string     `gorm:"size:255;not null;" json:"title"`
+	Description string     `gorm:"size:255;not null;" json:"description"`
+	Status      TaskStatus `gorm:"size:255;not null;" json:"status"`
+	// Shared marks a task listed for a collaborator rather than its owner.
+	Shared     bool       `gorm:"-" json:"shared"`
+	ShareLevel ShareLevel `gorm:"-" json:"share_level,omitempty"`
 }
 
+// ReadAllTasksByUserID returns the personal tasks of the user. Tasks the user
+// created in a workspace belong to the workspace and are not included.
 func ReadAllTasksByUserID(userID uint, logger *log.Logger) ([]Task, error) {
 	var tasks []Task
-	if err := database.Database.Where("user_id = ?", userID).Find(&tasks).Error; err != nil {
+	if err := database.Database.Where("user_id = ? AND workspace_id = 0", userID).Find(&tasks).Error; err != nil {
 		logger.Printf("Error in reading task from database %v", err)
 		return nil, errors.New("error in reading task")
 	}
 	return tasks, nil
 }
 
-func (task *Task) SaveInTransaction(tx *gorm.DB, logger *log.Logger) (*Task, error) {
-	if task.ID == 0 {
-		err := tx.Create(task).Error
-		if err != nil {
-			logger.Println("Error in creating user", err)
-			return nil, errors.New("error in creating user")
-		}
-	} else {
-		err := tx.Save(task).Error
-		if err != nil {
-			logger.Println("Error in updating user", err)
-			return nil, errors.New("error in updating user")
-		}
+// ListTasks returns tasks of every user, or only of userID if it is not 0,
+// oldest first.
+func ListTasks(userID uint, limit, offset int) ([]Task, error) {
+	var tasks []Task
+	query := database.Database.Order("id").Limit(limit).Offset(offset)
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	if err := query.Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// CreateInTransaction inserts the task as a new row. Its ID and timestamps
+// are cleared first, so a task can never overwrite or revive an existing one.
+func (task *Task) CreateInTransaction(tx *gorm.DB, logger *log.Logger) (*Task, error) {
+	task.Model = gorm.Model{}
+	if err := tx.Create(task).Error; err != nil {
+		logger.Println("Error in creating task", err)
+		return nil, errors.New("error in creating task")
 	}
 	return task, nil
 }
@@ -0,0 +1,127 @@
+package model
+
+import (
+	"errors"
+	"konzek_assg/database"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ShareLevel is what a collaborator may do with a task shared with them.
+type ShareLevel string
+
+const (
+	ShareViewer ShareLevel = "viewer"
+	ShareEditor ShareLevel = "editor"
+)
+
+var (
+	ErrTaskNotFound      = errors.New("task not found")
+	ErrInvalidShareLevel = errors.New("share level must be viewer or editor")
+	ErrShareNotFound     = errors.New("task is not shared with this user")
+)
+
+func (l ShareLevel) Valid() bool {
+	return l == ShareViewer || l == ShareEditor
+}
+
+// TaskShare grants a user other than the owner access to a task.
+type TaskShare struct {
+	ID        uint       `gorm:"primaryKey" json:"-"`
+	TaskID    uint       `gorm:"not null;uniqueIndex:idx_task_shares_task_user" json:"taskid"`
+	UserID    uint       `gorm:"not null;uniqueIndex:idx_task_shares_task_user;index" json:"userid"`
+	Level     ShareLevel `gorm:"size:16;not null" json:"level"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func init() {
+	database.RegisterModels(&TaskShare{})
+}
+
+func FindTask(id uint) (Task, error) {
+	var task Task
+	if err := database.Database.First(&task, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return Task{}, ErrTaskNotFound
+		}
+		return Task{}, err
+	}
+	return task, nil
+}
+
+// ShareTask shares the task with the user, changing the level if it is
+// already shared.
+func ShareTask(taskID, userID uint, level ShareLevel) (TaskShare, error) {
+	if !level.Valid() {
+		return TaskShare{}, ErrInvalidShareLevel
+	}
+	share := TaskShare{TaskID: taskID, UserID: userID, Level: level}
+	err := database.Database.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "task_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"level"}),
+	}).Create(&share).Error
+	return share, err
+}
+
+func UnshareTask(taskID, userID uint) error {
+	result := database.Database.Where("task_id = ? AND user_id = ?", taskID, userID).Delete(&TaskShare{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrShareNotFound
+	}
+	return nil
+}
+
+func ListTaskShares(taskID uint) ([]TaskShare, error) {
+	var shares []TaskShare
+	if err := database.Database.Where("task_id = ?", taskID).Order("id").Find(&shares).Error; err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+// FindShareLevel returns the level the task is shared with the user at, or
+// ErrShareNotFound.
+func FindShareLevel(taskID, userID uint) (ShareLevel, error) {
+	var share TaskShare
+	err := database.Database.Where("task_id = ? AND user_id = ?", taskID, userID).First(&share).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrShareNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return share.Level, nil
+}
+
+// ReadTasksSharedWith returns the tasks other users shared with userID,
+// flagged with the level they were shared at.
+func ReadTasksSharedWith(userID uint) ([]Task, error) {
+	var shares []TaskShare
+	if err := database.Database.Where("user_id = ?", userID).Find(&shares).Error; err != nil {
+		return nil, err
+	}
+	if len(shares) == 0 {
+		return nil, nil
+	}
+
+	levels := make(map[uint]ShareLevel, len(shares))
+	ids := make([]uint, 0, len(shares))
+	for _, share := range shares {
+		levels[share.TaskID] = share.Level
+		ids = append(ids, share.TaskID)
+	}
+	var tasks []Task
+	if err := database.Database.Where("id IN ?", ids).Order("id").Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	for i := range tasks {
+		tasks[i].Shared = true
+		tasks[i].ShareLevel = levels[tasks[i].ID]
+	}
+	return tasks, nil
+}
@@ -4,16 +4,24 @@ import (
 	"errors"
 	"fmt"
 	"konzek_assg/database"
+	"konzek_assg/password"
 
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
 type User struct {
 	gorm.Model
 	Username string `gorm:"size:255;not null;unique" json:"username"`
-	Password string `gorm:"size:255;not null;" json:"password"`
-	Tasks    []Task `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+	Password string `gorm:"size:255;not null;" json:"-"`
+	// PlainPassword is a password from input, hashed into Password by
+	// BeforeSave. It is never stored.
+	PlainPassword string `gorm:"-" json:"-"`
+	DisplayName   string `gorm:"size:255;not null;default:''" json:"display_name"`
+	Email         string `gorm:"size:255;not null;default:''" json:"email"`
+	Timezone      string `gorm:"size:64;not null;default:'UTC'" json:"timezone"`
+	Locale        string `gorm:"size:35;not null;default:'en'" json:"locale"`
+	Tasks         []Task `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;" json:"tasks,omitempty"`
+	Roles         []Role `gorm:"many2many:user_roles;" json:"roles,omitempty"`
 }
 
 func (user *User) SaveInTransaction(tx *gorm.DB) (*User, error) {
@@ -31,25 +39,116 @@ func (user *User) SaveInTransaction(tx *gorm.DB) (*User, error) {
 	return user, nil
 }
 
-func (user *User) ValidatePassword(password string) error {
-	return bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+// BeforeSave hashes PlainPassword into Password so a plaintext password is
+// never written to the database. Passwords from input always go through
+// PlainPassword: a value that merely looks like a hash is hashed like any
+// other password, so nobody can pick their own. A Password set directly is
+// hashed too unless it already is a hash.
+func (user *User) BeforeSave(tx *gorm.DB) error {
+	plain := user.PlainPassword
+	if plain == "" {
+		if user.Password == "" || password.IsHash(user.Password) {
+			return nil
+		}
+		plain = user.Password
+	}
+	hash, err := password.Hash(plain)
+	if err != nil {
+		return err
+	}
+	user.Password = hash
+	user.PlainPassword = ""
+	return nil
+}
+
+func (user *User) ValidatePassword(plain string) error {
+	return password.Verify(user.Password, plain)
+}
 
+// RehashPassword stores a new hash of plain, which must already have been
+// validated, if the stored hash was made with outdated parameters.
+func (user *User) RehashPassword(plain string) (bool, error) {
+	if !password.NeedsRehash(user.Password) {
+		return false, nil
+	}
+	hash, err := password.Hash(plain)
+	if err != nil {
+		return false, err
+	}
+	if err := database.Database.Model(user).Update("password", hash).Error; err != nil {
+		return false, err
+	}
+	user.Password = hash
+	return true, nil
+}
+
+// HashPlaintextPasswords hashes the passwords of users stored before
+// passwords were hashed and returns how many rows it changed.
+func HashPlaintextPasswords() (int, error) {
+	var users []User
+	hashed := 0
+	result := database.Database.Unscoped().Select("id", "password").FindInBatches(&users, 100, func(tx *gorm.DB, batch int) error {
+		for _, user := range users {
+			if password.IsHash(user.Password) {
+				continue
+			}
+			hash, err := password.Hash(user.Password)
+			if err != nil {
+				return err
+			}
+			if err := database.Database.Unscoped().Model(&User{}).Where("id = ?", user.ID).UpdateColumn("password", hash).Error; err != nil {
+				return fmt.Errorf("hashing password of user %d: %w", user.ID, err)
+			}
+			hashed++
+		}
+		return nil
+	})
+	return hashed, result.Error
 }
 
 func FindUserByUsername(username string) (User, error) {
 	var user User
-	err := database.Database.Where("username=?", username).Find(&user).Error
+	err := database.Database.Preload("Roles.Permissions").Where("username=?", username).Find(&user).Error
 	if err != nil {
 		return User{}, fmt.Errorf("there is no such a user")
 	}
 	return user, nil
 }
 
+var ErrUserNotFound = errors.New("user not found")
+
+// FindUserById loads the user without its tasks.
 func FindUserById(id uint) (User, error) {
 	var user User
-	err := database.Database.Preload("Tasks").Where("ID=?", id).Find(&user).Error
+	err := database.Database.Where("ID=?", id).First(&user).Error
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return User{}, ErrUserNotFound
+		}
 		return User{}, err
 	}
 	return user, nil
 }
+
+// FindUserWithRoles loads the user with its roles and their permissions, as
+// needed to issue a token.
+func FindUserWithRoles(id uint) (User, error) {
+	var user User
+	err := database.Database.Preload("Roles.Permissions").Where("ID=?", id).First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return User{}, ErrUserNotFound
+		}
+		return User{}, err
+	}
+	return user, nil
+}
+
+// ListUsers returns users with their roles, oldest first.
+func ListUsers(limit, offset int) ([]User, error) {
+	var users []User
+	if err := database.Database.Preload("Roles").Order("id").Limit(limit).Offset(offset).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
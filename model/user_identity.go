@@ -0,0 +1,176 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"konzek_assg/database"
+	"regexp"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrIdentityNotFound = errors.New("external identity not linked")
+	ErrIdentityLinked   = errors.New("external identity is already linked to a user")
+	ErrOIDCStateInvalid = errors.New("invalid or expired login state")
+)
+
+// UserIdentity links a user to the subject an external OpenID Connect
+// provider knows them by. A user has at most one identity per provider.
+type UserIdentity struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_user_identities_user_provider" json:"userid"`
+	Provider  string    `gorm:"size:64;not null;uniqueIndex:idx_user_identities_provider_subject;uniqueIndex:idx_user_identities_user_provider" json:"provider"`
+	Subject   string    `gorm:"size:255;not null;uniqueIndex:idx_user_identities_provider_subject" json:"subject"`
+	Email     string    `gorm:"size:255" json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OIDCLoginState remembers a login sent to a provider until it comes back,
+// stored by the hash of its state parameter. LinkUserID is set when a
+// logged-in user links the identity instead of logging in with it.
+type OIDCLoginState struct {
+	StateHash    string    `gorm:"primaryKey;size:64"`
+	Provider     string    `gorm:"size:64;not null"`
+	Nonce        string    `gorm:"size:64;not null"`
+	CodeVerifier string    `gorm:"size:128;not null"`
+	LinkUserID   uint      `gorm:"not null"`
+	ExpiresAt    time.Time `gorm:"not null;index"`
+}
+
+func init() {
+	database.RegisterModels(&UserIdentity{}, &OIDCLoginState{})
+}
+
+func CreateOIDCLoginState(state *OIDCLoginState) error {
+	return database.Database.Create(state).Error
+}
+
+// ConsumeOIDCLoginState deletes and returns the unexpired state of provider
+// stored under hash, so each state is used once. Expired states are swept
+// along the way.
+func ConsumeOIDCLoginState(provider, hash string) (OIDCLoginState, error) {
+	var state OIDCLoginState
+	err := database.Database.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.Returning{}).
+			Where("state_hash = ? AND provider = ? AND expires_at > ?", hash, provider, time.Now()).
+			Delete(&state)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrOIDCStateInvalid
+		}
+		return tx.Where("expires_at <= ?", time.Now()).Delete(&OIDCLoginState{}).Error
+	})
+	return state, err
+}
+
+// FindUserByIdentity returns the user, with roles, linked to the subject of
+// provider.
+func FindUserByIdentity(provider, subject string) (User, error) {
+	var identity UserIdentity
+	err := database.Database.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return User{}, ErrIdentityNotFound
+	}
+	if err != nil {
+		return User{}, err
+	}
+	user, err := FindUserWithRoles(identity.UserID)
+	if errors.Is(err, ErrUserNotFound) {
+		return User{}, ErrIdentityNotFound
+	}
+	return user, err
+}
+
+// LinkIdentity links the identity to identity.UserID. Linking it again to
+// the same user is a no-op.
+func LinkIdentity(identity *UserIdentity) error {
+	return database.Database.Transaction(func(tx *gorm.DB) error {
+		return linkIdentity(tx, identity)
+	})
+}
+
+func linkIdentity(tx *gorm.DB, identity *UserIdentity) error {
+	var existing []UserIdentity
+	err := tx.Where("(provider = ? AND subject = ?) OR (provider = ? AND user_id = ?)",
+		identity.Provider, identity.Subject, identity.Provider, identity.UserID).Find(&existing).Error
+	if err != nil {
+		return err
+	}
+	for _, other := range existing {
+		if other.UserID == identity.UserID && other.Subject == identity.Subject {
+			*identity = other
+			return nil
+		}
+	}
+	if len(existing) > 0 {
+		return ErrIdentityLinked
+	}
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(identity)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrIdentityLinked
+	}
+	return nil
+}
+
+var usernameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// CreateUserWithIdentity creates user, linked to identity, for someone who
+// first logs in through a provider. The username is made unique by adding a
+// number if it is taken.
+func CreateUserWithIdentity(user *User, identity *UserIdentity) error {
+	base := usernameUnsafe.ReplaceAllString(user.Username, "")
+	if len(base) > 64 {
+		base = base[:64]
+	}
+	if base == "" {
+		base = "user"
+	}
+	return database.Database.Transaction(func(tx *gorm.DB) error {
+		for n := 1; n <= 100; n++ {
+			user.Username = base
+			if n > 1 {
+				user.Username = fmt.Sprintf("%s-%d", base, n)
+			}
+			var taken int64
+			if err := tx.Unscoped().Model(&User{}).Where("username = ?", user.Username).Count(&taken).Error; err != nil {
+				return err
+			}
+			if taken > 0 {
+				continue
+			}
+			// A concurrent first login may take the name before the insert;
+			// then the next one is tried.
+			if err := tx.SavePoint("username").Error; err != nil {
+				return err
+			}
+			err := tx.Create(user).Error
+			if isUniqueViolation(err) {
+				if err := tx.RollbackTo("username").Error; err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			identity.UserID = user.ID
+			return linkIdentity(tx, identity)
+		}
+		return fmt.Errorf("no free username like %q", base)
+	})
+}
+
+// isUniqueViolation reports whether err is a Postgres unique_violation.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
@@ -0,0 +1,166 @@
+package model
+
+import (
+	"errors"
+	"konzek_assg/database"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// WorkspaceRole is what a member may do inside a workspace.
+type WorkspaceRole string
+
+const (
+	WorkspaceOwner  WorkspaceRole = "owner"
+	WorkspaceMember WorkspaceRole = "member"
+	WorkspaceViewer WorkspaceRole = "viewer"
+)
+
+var (
+	ErrWorkspaceNotFound     = errors.New("workspace not found")
+	ErrNotWorkspaceMember    = errors.New("not a member of this workspace")
+	ErrInvalidWorkspaceRole  = errors.New("workspace role must be owner, member or viewer")
+	ErrLastWorkspaceOwner    = errors.New("a workspace needs at least one owner")
+	ErrWorkspaceNameRequired = errors.New("workspace name is required")
+)
+
+func (r WorkspaceRole) Valid() bool {
+	return r == WorkspaceOwner || r == WorkspaceMember || r == WorkspaceViewer
+}
+
+// CanWriteTasks reports whether the role may create, change and delete the
+// workspace's tasks.
+func (r WorkspaceRole) CanWriteTasks() bool {
+	return r == WorkspaceOwner || r == WorkspaceMember
+}
+
+// Workspace owns tasks shared by a team. Tasks with WorkspaceID 0 are the
+// personal tasks of their user.
+type Workspace struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"size:255;not null" json:"name"`
+	CreatedBy uint      `gorm:"not null" json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type WorkspaceMembership struct {
+	WorkspaceID uint          `gorm:"primaryKey;autoIncrement:false" json:"workspace_id"`
+	UserID      uint          `gorm:"primaryKey;autoIncrement:false;index" json:"userid"`
+	Role        WorkspaceRole `gorm:"size:16;not null" json:"role"`
+	CreatedAt   time.Time     `json:"created_at"`
+}
+
+func init() {
+	database.RegisterModels(&Workspace{}, &WorkspaceMembership{})
+}
+
+// CreateWorkspace creates the workspace with its creator as owner.
+func CreateWorkspace(name string, creatorID uint) (Workspace, error) {
+	if name == "" {
+		return Workspace{}, ErrWorkspaceNameRequired
+	}
+	workspace := Workspace{Name: name, CreatedBy: creatorID}
+	err := database.Database.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&workspace).Error; err != nil {
+			return err
+		}
+		return tx.Create(&WorkspaceMembership{WorkspaceID: workspace.ID, UserID: creatorID, Role: WorkspaceOwner}).Error
+	})
+	return workspace, err
+}
+
+// ListWorkspacesForUser returns the workspaces the user is a member of.
+func ListWorkspacesForUser(userID uint) ([]Workspace, error) {
+	var workspaces []Workspace
+	err := database.Database.
+		Joins("JOIN workspace_memberships ON workspace_memberships.workspace_id = workspaces.id").
+		Where("workspace_memberships.user_id = ?", userID).
+		Order("workspaces.id").
+		Find(&workspaces).Error
+	return workspaces, err
+}
+
+// FindWorkspaceRole returns the user's role in the workspace. Non-members get
+// ErrNotWorkspaceMember, and so do members of missing workspaces, so callers
+// cannot probe which workspaces exist.
+func FindWorkspaceRole(workspaceID, userID uint) (WorkspaceRole, error) {
+	var membership WorkspaceMembership
+	err := database.Database.Where("workspace_id = ? AND user_id = ?", workspaceID, userID).First(&membership).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrNotWorkspaceMember
+	}
+	if err != nil {
+		return "", err
+	}
+	return membership.Role, nil
+}
+
+func ListWorkspaceMembers(workspaceID uint) ([]WorkspaceMembership, error) {
+	var members []WorkspaceMembership
+	err := database.Database.Where("workspace_id = ?", workspaceID).Order("created_at").Find(&members).Error
+	return members, err
+}
+
+// SetWorkspaceMember adds the user to the workspace or changes their role.
+func SetWorkspaceMember(workspaceID, userID uint, role WorkspaceRole) (WorkspaceMembership, error) {
+	if !role.Valid() {
+		return WorkspaceMembership{}, ErrInvalidWorkspaceRole
+	}
+	membership := WorkspaceMembership{WorkspaceID: workspaceID, UserID: userID, Role: role}
+	err := database.Database.Transaction(func(tx *gorm.DB) error {
+		if role != WorkspaceOwner {
+			if err := ensureAnotherOwner(tx, workspaceID, userID); err != nil {
+				return err
+			}
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "workspace_id"}, {Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"role"}),
+		}).Create(&membership).Error
+	})
+	return membership, err
+}
+
+func RemoveWorkspaceMember(workspaceID, userID uint) error {
+	return database.Database.Transaction(func(tx *gorm.DB) error {
+		if err := ensureAnotherOwner(tx, workspaceID, userID); err != nil {
+			return err
+		}
+		result := tx.Where("workspace_id = ? AND user_id = ?", workspaceID, userID).Delete(&WorkspaceMembership{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotWorkspaceMember
+		}
+		return nil
+	})
+}
+
+// ensureAnotherOwner fails if userID is the only owner of the workspace, so
+// demoting or removing them would leave it without one.
+func ensureAnotherOwner(tx *gorm.DB, workspaceID, userID uint) error {
+	var owners []WorkspaceMembership
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("workspace_id = ? AND role = ?", workspaceID, WorkspaceOwner).
+		Find(&owners).Error
+	if err != nil {
+		return err
+	}
+	if len(owners) == 1 && owners[0].UserID == userID {
+		return ErrLastWorkspaceOwner
+	}
+	return nil
+}
+
+// ReadWorkspaceTasks returns the tasks of the workspace.
+func ReadWorkspaceTasks(workspaceID uint) ([]Task, error) {
+	var tasks []Task
+	if err := database.Database.Where("workspace_id = ?", workspaceID).Order("id").Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
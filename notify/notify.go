@@ -0,0 +1,67 @@
+// Package notify delivers messages to users outside the API, such as
+// password reset tokens. Users have no e-mail address yet, so the notifiers
+// here are meant for local use; a real delivery channel implements Notifier.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// PasswordReset carries a reset token to the user it was issued for.
+type PasswordReset struct {
+	UserID    uint      `json:"userid"`
+	Username  string    `json:"username"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type Notifier interface {
+	PasswordReset(ctx context.Context, reset PasswordReset) error
+}
+
+// LogNotifier writes the messages to a logger.
+type LogNotifier struct {
+	logger *log.Logger
+}
+
+func NewLogNotifier(logger *log.Logger) *LogNotifier {
+	return &LogNotifier{logger: logger}
+}
+
+func (n *LogNotifier) PasswordReset(ctx context.Context, reset PasswordReset) error {
+	n.logger.Printf("Password reset token for user %s, valid until %s: %s\n", reset.Username, reset.ExpiresAt.Format(time.RFC3339), reset.Token)
+	return nil
+}
+
+// FileNotifier appends the messages to a file, one JSON object per line.
+type FileNotifier struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+func (n *FileNotifier) PasswordReset(ctx context.Context, reset PasswordReset) error {
+	line, err := json.Marshal(reset)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	file, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,44 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileNotifierAppendsJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resets.log")
+	notifier := NewFileNotifier(path)
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	for _, token := range []string{"first", "second"} {
+		reset := PasswordReset{UserID: 1, Username: "alice", Token: token, ExpiresAt: expiresAt}
+		if err := notifier.PasswordReset(context.Background(), reset); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var tokens []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var reset PasswordReset
+		if err := json.Unmarshal(scanner.Bytes(), &reset); err != nil {
+			t.Fatal(err)
+		}
+		if !reset.ExpiresAt.Equal(expiresAt) || reset.Username != "alice" {
+			t.Errorf("unexpected entry %+v", reset)
+		}
+		tokens = append(tokens, reset.Token)
+	}
+	if len(tokens) != 2 || tokens[0] != "first" || tokens[1] != "second" {
+		t.Errorf("tokens = %v, want [first second]", tokens)
+	}
+}
@@ -0,0 +1,148 @@
+package oidc_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"konzek_assg/oidc"
+	"konzek_assg/oidc/oidctest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func login(t *testing.T, server *oidctest.Server, provider *oidc.Provider, challenge string) (code, state string) {
+	t.Helper()
+	authURL, err := provider.AuthCodeURL(context.Background(), "the-state", "the-nonce", challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback, err := server.Login(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if callback.Host != "app.example" || callback.Path != "/auth/oidc/test/callback" {
+		t.Fatalf("redirected to %s", callback)
+	}
+	return callback.Query().Get("code"), callback.Query().Get("state")
+}
+
+func newProvider(server *oidctest.Server, secret string) *oidc.Provider {
+	return oidc.NewProvider(oidc.Config{
+		Name:         "test",
+		Issuer:       server.Issuer(),
+		ClientID:     server.ClientID,
+		ClientSecret: secret,
+		RedirectURL:  "https://app.example/auth/oidc/test/callback",
+		Scopes:       []string{"profile", "email"},
+	}, nil)
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	for _, secret := range []string{"s3cret", ""} {
+		server := oidctest.NewServer("client", secret)
+		provider := newProvider(server, secret)
+
+		verifier, challenge, err := oidc.NewPKCE()
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, state := login(t, server, provider, challenge)
+		if state != "the-state" {
+			t.Errorf("state = %q", state)
+		}
+		raw, err := provider.Exchange(context.Background(), code, verifier)
+		if err != nil {
+			t.Fatalf("secret %q: %v", secret, err)
+		}
+		token, err := provider.VerifyIDToken(context.Background(), raw, "the-nonce")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.Subject != "subject-1" || token.Email != "jane@example.com" || token.PreferredUsername != "jane" {
+			t.Errorf("claims = %+v", token)
+		}
+
+		// A code works once.
+		if _, err := provider.Exchange(context.Background(), code, verifier); err == nil {
+			t.Error("code redeemed twice")
+		}
+		server.Close()
+	}
+}
+
+func TestExchangeNeedsTheVerifier(t *testing.T) {
+	server := oidctest.NewServer("client", "s3cret")
+	defer server.Close()
+	provider := newProvider(server, "s3cret")
+
+	_, challenge, _ := oidc.NewPKCE()
+	code, _ := login(t, server, provider, challenge)
+	other, _, _ := oidc.NewPKCE()
+	if _, err := provider.Exchange(context.Background(), code, other); err == nil {
+		t.Fatal("exchanged a code with the wrong verifier")
+	}
+}
+
+func TestVerifyIDTokenChecksNonceAndAudience(t *testing.T) {
+	server := oidctest.NewServer("client", "s3cret")
+	defer server.Close()
+	provider := newProvider(server, "s3cret")
+
+	verifier, challenge, _ := oidc.NewPKCE()
+	code, _ := login(t, server, provider, challenge)
+	raw, err := provider.Exchange(context.Background(), code, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.VerifyIDToken(context.Background(), raw, "another-nonce"); !errors.Is(err, oidc.ErrNonceMismatch) {
+		t.Errorf("wrong nonce: err = %v", err)
+	}
+
+	stranger := oidc.NewProvider(oidc.Config{Issuer: server.Issuer(), ClientID: "someone-else"}, nil)
+	if _, err := stranger.VerifyIDToken(context.Background(), raw, "the-nonce"); !errors.Is(err, oidc.ErrInvalidIDToken) {
+		t.Errorf("wrong audience: err = %v", err)
+	}
+
+	tampered := raw[:len(raw)-4] + "AAAA"
+	if _, err := provider.VerifyIDToken(context.Background(), tampered, "the-nonce"); !errors.Is(err, oidc.ErrInvalidIDToken) {
+		t.Errorf("bad signature: err = %v", err)
+	}
+}
+
+func TestKeySetIsFetchedOnceAndNotForEveryUnknownKey(t *testing.T) {
+	server := oidctest.NewServer("client", "s3cret")
+	defer server.Close()
+	provider := newProvider(server, "s3cret")
+
+	verifier, challenge, _ := oidc.NewPKCE()
+	code, _ := login(t, server, provider, challenge)
+	raw, err := provider.Exchange(context.Background(), code, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.SplitN(raw, ".", 2)
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"unknown","typ":"JWT"}`))
+	unknownKey := header + "." + parts[1]
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := provider.VerifyIDToken(context.Background(), raw, "the-nonce"); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := provider.VerifyIDToken(context.Background(), unknownKey, "the-nonce"); !errors.Is(err, oidc.ErrInvalidIDToken) {
+				t.Errorf("token signed with an unknown key: err = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := server.JWKSRequests(); n != 1 {
+		t.Errorf("key set fetched %d times, want once", n)
+	}
+}
@@ -0,0 +1,214 @@
+// Package password hashes and verifies user passwords with bcrypt or
+// argon2id. Hashes are self-describing, so a stored hash verifies regardless
+// of the current settings and NeedsRehash reports when it should be upgraded.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"konzek_assg/config"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+var (
+	ErrMismatch    = errors.New("password does not match")
+	ErrUnknownHash = errors.New("unrecognised password hash")
+)
+
+var (
+	mu       sync.RWMutex
+	settings = config.Default().Password
+	// denylist holds the lowercased passwords of settings.DenylistFile.
+	denylist map[string]bool
+	// dummyHash is what VerifyNothing compares against; it is made with the
+	// current settings on first use.
+	dummyHash string
+)
+
+// Configure sets the algorithm and cost used for new hashes and the policy
+// new passwords must meet, loading its denylist file.
+func Configure(cfg config.Password) error {
+	var denied map[string]bool
+	if cfg.DenylistFile != "" {
+		var err error
+		if denied, err = loadDenylist(cfg.DenylistFile); err != nil {
+			return err
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	settings = cfg
+	denylist = denied
+	dummyHash = ""
+	return nil
+}
+
+func current() config.Password {
+	mu.RLock()
+	defer mu.RUnlock()
+	return settings
+}
+
+// Hash returns a hash of plain using the configured algorithm.
+func Hash(plain string) (string, error) {
+	cfg := current()
+	if cfg.Algorithm == Bcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(plain), cfg.BcryptCost)
+		if err != nil {
+			return "", fmt.Errorf("hashing password: %w", err)
+		}
+		return string(hash), nil
+	}
+
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("hashing password: %w", err)
+	}
+	params := argon2Params{
+		memory:      uint32(cfg.Argon2Memory),
+		iterations:  uint32(cfg.Argon2Iterations),
+		parallelism: uint8(cfg.Argon2Parallelism),
+	}
+	key := argon2.IDKey([]byte(plain), salt, params.iterations, params.memory, params.parallelism, argon2KeyLength)
+	return params.encode(salt, key), nil
+}
+
+// Verify checks plain against hash. It returns ErrMismatch for a wrong
+// password and ErrUnknownHash when hash is not a bcrypt or argon2id hash.
+func Verify(hash, plain string) error {
+	switch {
+	case isBcrypt(hash):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(plain))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrMismatch
+		}
+		return err
+	case strings.HasPrefix(hash, "$argon2id$"):
+		params, salt, key, err := decodeArgon2(hash)
+		if err != nil {
+			return err
+		}
+		other := argon2.IDKey([]byte(plain), salt, params.iterations, params.memory, params.parallelism, uint32(len(key)))
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return ErrMismatch
+		}
+		return nil
+	default:
+		return ErrUnknownHash
+	}
+}
+
+// VerifyNothing takes as long as verifying plain against a hash made with
+// the current settings and always returns ErrMismatch. Logins for unknown
+// users call it so they cannot be told apart from wrong passwords by timing.
+func VerifyNothing(plain string) error {
+	mu.RLock()
+	hash := dummyHash
+	mu.RUnlock()
+	if hash == "" {
+		random := make([]byte, 16)
+		if _, err := rand.Read(random); err != nil {
+			return err
+		}
+		var err error
+		if hash, err = Hash(base64.RawStdEncoding.EncodeToString(random)); err != nil {
+			return err
+		}
+		mu.Lock()
+		dummyHash = hash
+		mu.Unlock()
+	}
+	if err := Verify(hash, plain); err != nil {
+		return err
+	}
+	return ErrMismatch
+}
+
+// NeedsRehash reports whether hash was made with another algorithm or other
+// parameters than the configured ones.
+func NeedsRehash(hash string) bool {
+	cfg := current()
+	if cfg.Algorithm == Bcrypt {
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost != cfg.BcryptCost
+	}
+
+	params, _, key, err := decodeArgon2(hash)
+	if err != nil {
+		return true
+	}
+	return params.memory != uint32(cfg.Argon2Memory) ||
+		params.iterations != uint32(cfg.Argon2Iterations) ||
+		params.parallelism != uint8(cfg.Argon2Parallelism) ||
+		len(key) != argon2KeyLength
+}
+
+// IsHash reports whether value is a hash this package can verify, as opposed
+// to a plaintext password.
+func IsHash(value string) bool {
+	if isBcrypt(value) {
+		return true
+	}
+	_, _, _, err := decodeArgon2(value)
+	return err == nil
+}
+
+func isBcrypt(value string) bool {
+	_, err := bcrypt.Cost([]byte(value))
+	return err == nil
+}
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+// encode writes the hash in the PHC string format used by the reference
+// implementation: $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>.
+func (p argon2Params) encode(salt, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.memory, p.iterations, p.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key))
+}
+
+func decodeArgon2(hash string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != Argon2id {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnknownHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrUnknownHash
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,79 @@
+package password
+
+import (
+	"errors"
+	"konzek_assg/config"
+	"testing"
+)
+
+func testSettings(algorithm string) config.Password {
+	return config.Password{
+		Algorithm:         algorithm,
+		BcryptCost:        4,
+		Argon2Memory:      64,
+		Argon2Iterations:  1,
+		Argon2Parallelism: 1,
+	}
+}
+
+func TestHashAndVerify(t *testing.T) {
+	for _, algorithm := range []string{Bcrypt, Argon2id} {
+		Configure(testSettings(algorithm))
+
+		hash, err := Hash("correct horse")
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		if !IsHash(hash) {
+			t.Errorf("%s: IsHash(%q) = false", algorithm, hash)
+		}
+		if err := Verify(hash, "correct horse"); err != nil {
+			t.Errorf("%s: Verify with the right password: %v", algorithm, err)
+		}
+		if err := Verify(hash, "wrong horse"); !errors.Is(err, ErrMismatch) {
+			t.Errorf("%s: Verify with a wrong password = %v, want ErrMismatch", algorithm, err)
+		}
+		if NeedsRehash(hash) {
+			t.Errorf("%s: fresh hash needs rehash", algorithm)
+		}
+	}
+}
+
+func TestNeedsRehashWhenSettingsChange(t *testing.T) {
+	Configure(testSettings(Bcrypt))
+	bcryptHash, _ := Hash("secret")
+
+	Configure(testSettings(Argon2id))
+	if !NeedsRehash(bcryptHash) {
+		t.Error("bcrypt hash should be rehashed once argon2id is configured")
+	}
+	if err := Verify(bcryptHash, "secret"); err != nil {
+		t.Errorf("bcrypt hash no longer verifies: %v", err)
+	}
+
+	argonHash, _ := Hash("secret")
+	stronger := testSettings(Argon2id)
+	stronger.Argon2Iterations = 2
+	Configure(stronger)
+	if !NeedsRehash(argonHash) {
+		t.Error("argon2id hash should be rehashed when the iterations change")
+	}
+}
+
+func TestPlaintextIsNotAHash(t *testing.T) {
+	for _, value := range []string{"", "hunter2", "$argon2id$garbage", "$2a$10$short"} {
+		if IsHash(value) {
+			t.Errorf("IsHash(%q) = true", value)
+		}
+	}
+	if err := Verify("hunter2", "hunter2"); !errors.Is(err, ErrUnknownHash) {
+		t.Errorf("Verify against plaintext = %v, want ErrUnknownHash", err)
+	}
+}
+
+func TestVerifyNothingAlwaysMismatches(t *testing.T) {
+	Configure(testSettings(Argon2id))
+	if err := VerifyNothing(""); !errors.Is(err, ErrMismatch) {
+		t.Errorf("VerifyNothing = %v, want ErrMismatch", err)
+	}
+}
@@ -0,0 +1,105 @@
+package password
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var ErrPolicy = errors.New("password does not meet the policy")
+
+// PolicyError lists every rule a new password breaks.
+type PolicyError struct {
+	Problems []string
+}
+
+func (e *PolicyError) Error() string {
+	return "password " + strings.Join(e.Problems, ", ")
+}
+
+func (e *PolicyError) Unwrap() error {
+	return ErrPolicy
+}
+
+// CheckPolicy reports whether plain may be set as the password of username.
+// It returns a *PolicyError if not.
+func CheckPolicy(plain, username string) error {
+	mu.RLock()
+	cfg, denied := settings, denylist
+	mu.RUnlock()
+
+	var problems []string
+	length := utf8.RuneCountInString(plain)
+	if length < cfg.MinLength {
+		problems = append(problems, fmt.Sprintf("must be at least %d characters long", cfg.MinLength))
+	}
+	if length > cfg.MaxLength {
+		problems = append(problems, fmt.Sprintf("must be at most %d characters long", cfg.MaxLength))
+	}
+	if classes := characterClasses(plain); classes < cfg.MinClasses {
+		problems = append(problems, fmt.Sprintf("must mix at least %d of lowercase letters, uppercase letters, digits and symbols", cfg.MinClasses))
+	}
+	lower := strings.ToLower(plain)
+	if username != "" && lower == strings.ToLower(username) {
+		problems = append(problems, "must not be the username")
+	}
+	if denied[lower] {
+		problems = append(problems, "is too common")
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return &PolicyError{Problems: problems}
+}
+
+func characterClasses(plain string) int {
+	var lower, upper, digit, symbol bool
+	for _, r := range plain {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			classes++
+		}
+	}
+	return classes
+}
+
+// loadDenylist reads one password per line; blank lines and lines starting
+// with # are skipped. Entries match case-insensitively.
+func loadDenylist(path string) (map[string]bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("password denylist: %w", err)
+	}
+	defer file.Close()
+
+	denied := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		denied[strings.ToLower(line)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("password denylist: %w", err)
+	}
+	return denied, nil
+}
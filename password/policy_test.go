@@ -0,0 +1,39 @@
+package password
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckPolicy(t *testing.T) {
+	denylist := filepath.Join(t.TempDir(), "common.txt")
+	if err := os.WriteFile(denylist, []byte("# common passwords\nCorrectHorse1\n\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := testSettings(Bcrypt)
+	cfg.MinLength = 8
+	cfg.MaxLength = 16
+	cfg.MinClasses = 3
+	cfg.DenylistFile = denylist
+	if err := Configure(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CheckPolicy("Tr0ub4dor&3", "alice"); err != nil {
+		t.Errorf("strong password refused: %v", err)
+	}
+	for _, plain := range []string{"Sh0rt", "alllowercase", "Th1s1sWayTooL0ngToBeAccepted", "correcthorse1", "Alice12345"} {
+		err := CheckPolicy(plain, "alice12345")
+		var policyErr *PolicyError
+		if !errors.Is(err, ErrPolicy) || !errors.As(err, &policyErr) || len(policyErr.Problems) == 0 {
+			t.Errorf("CheckPolicy(%q) = %v, want a policy error", plain, err)
+		}
+	}
+
+	cfg.DenylistFile = filepath.Join(t.TempDir(), "missing.txt")
+	if err := Configure(cfg); err == nil {
+		t.Error("Configure accepted a missing denylist file")
+	}
+}
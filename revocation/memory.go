@@ -0,0 +1,84 @@
+package revocation
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type userEntry struct {
+	before    time.Time
+	expiresAt time.Time
+}
+
+// MemoryStore keeps the denylist in process. Revocations are lost on restart
+// and not shared between instances.
+type MemoryStore struct {
+	mu        sync.Mutex
+	tokens    map[string]time.Time
+	users     map[uint]userEntry
+	lastPurge time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tokens: make(map[string]time.Time),
+		users:  make(map[uint]userEntry),
+		now:    time.Now,
+	}
+}
+
+func (s *MemoryStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purgeLocked()
+	s.tokens[jti] = expiresAt
+	return nil
+}
+
+func (s *MemoryStore) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expiresAt, ok := s.tokens[jti]
+	return ok && s.now().Before(expiresAt), nil
+}
+
+func (s *MemoryStore) RevokeUser(ctx context.Context, userID uint, before, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purgeLocked()
+	if entry, ok := s.users[userID]; ok && entry.before.After(before) {
+		return nil
+	}
+	s.users[userID] = userEntry{before: before, expiresAt: expiresAt}
+	return nil
+}
+
+func (s *MemoryStore) UserRevokedBefore(ctx context.Context, userID uint) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.users[userID]
+	if !ok || !s.now().Before(entry.expiresAt) {
+		return time.Time{}, nil
+	}
+	return entry.before, nil
+}
+
+func (s *MemoryStore) purgeLocked() {
+	now := s.now()
+	if now.Sub(s.lastPurge) < purgeInterval {
+		return
+	}
+	s.lastPurge = now
+	for jti, expiresAt := range s.tokens {
+		if !now.Before(expiresAt) {
+			delete(s.tokens, jti)
+		}
+	}
+	for userID, entry := range s.users {
+		if !now.Before(entry.expiresAt) {
+			delete(s.users, userID)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package revocation
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreForgetsExpiredEntries(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	store.RevokeToken(ctx, "a", now.Add(time.Minute))
+	if revoked, _ := store.IsTokenRevoked(ctx, "a"); !revoked {
+		t.Error("token a should be revoked")
+	}
+	if revoked, _ := store.IsTokenRevoked(ctx, "b"); revoked {
+		t.Error("token b was never revoked")
+	}
+
+	store.RevokeUser(ctx, 1, now, now.Add(time.Minute))
+	store.RevokeUser(ctx, 1, now.Add(-time.Hour), now.Add(time.Minute))
+	if before, _ := store.UserRevokedBefore(ctx, 1); !before.Equal(now) {
+		t.Errorf("cutoff moved backwards to %v", before)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if revoked, _ := store.IsTokenRevoked(ctx, "a"); revoked {
+		t.Error("token a is still revoked after its expiry")
+	}
+	if before, _ := store.UserRevokedBefore(ctx, 1); !before.IsZero() {
+		t.Errorf("user cutoff survived its expiry: %v", before)
+	}
+
+	store.RevokeToken(ctx, "c", now.Add(time.Minute))
+	if _, ok := store.tokens["a"]; ok {
+		t.Error("expired token a was not purged")
+	}
+}
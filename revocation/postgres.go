@@ -0,0 +1,80 @@
+package revocation
+
+import (
+	"context"
+	"errors"
+	"konzek_assg/model"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PostgresStore keeps the denylist in the revoked_tokens and
+// user_revocations tables, shared by every instance.
+type PostgresStore struct {
+	db *gorm.DB
+
+	mu        sync.Mutex
+	lastPurge time.Time
+}
+
+func NewPostgresStore(db *gorm.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	s.purge(ctx)
+	row := model.RevokedToken{JTI: jti, ExpiresAt: expiresAt}
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error
+}
+
+func (s *PostgresStore) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	var count int64
+	err := s.db.WithContext(ctx).Model(&model.RevokedToken{}).
+		Where("jti = ? AND expires_at > ?", jti, time.Now()).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (s *PostgresStore) RevokeUser(ctx context.Context, userID uint, before, expiresAt time.Time) error {
+	s.purge(ctx)
+	row := model.UserRevocation{UserID: userID, RevokedBefore: before, ExpiresAt: expiresAt}
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"revoked_before": gorm.Expr("GREATEST(user_revocations.revoked_before, excluded.revoked_before)"),
+			"expires_at":     gorm.Expr("GREATEST(user_revocations.expires_at, excluded.expires_at)"),
+		}),
+	}).Create(&row).Error
+}
+
+func (s *PostgresStore) UserRevokedBefore(ctx context.Context, userID uint) (time.Time, error) {
+	var row model.UserRevocation
+	err := s.db.WithContext(ctx).Where("user_id = ? AND expires_at > ?", userID, time.Now()).First(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return row.RevokedBefore, nil
+}
+
+// purge deletes expired rows at most once per purgeInterval. Failures only
+// leave harmless rows behind, so they are ignored.
+func (s *PostgresStore) purge(ctx context.Context) {
+	s.mu.Lock()
+	now := time.Now()
+	if now.Sub(s.lastPurge) < purgeInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastPurge = now
+	s.mu.Unlock()
+
+	db := s.db.WithContext(ctx)
+	db.Where("expires_at <= ?", now).Delete(&model.RevokedToken{})
+	db.Where("expires_at <= ?", now).Delete(&model.UserRevocation{})
+}
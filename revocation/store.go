@@ -0,0 +1,26 @@
+// Package revocation keeps track of access tokens revoked before they expire,
+// either one at a time by their jti or all tokens of a user at once.
+package revocation
+
+import (
+	"context"
+	"time"
+)
+
+// Store is a denylist of access tokens. Entries only need to be kept until
+// the tokens they cover have expired, so every entry carries an expiry after
+// which the store may forget it.
+type Store interface {
+	// RevokeToken revokes the token with the given jti.
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	// IsTokenRevoked reports whether the token with the given jti is revoked.
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	// RevokeUser revokes every token of the user issued at or before before.
+	RevokeUser(ctx context.Context, userID uint, before, expiresAt time.Time) error
+	// UserRevokedBefore returns the latest RevokeUser cutoff of the user, or
+	// the zero time if there is none.
+	UserRevokedBefore(ctx context.Context, userID uint) (time.Time, error)
+}
+
+// purgeInterval is how often stores drop expired entries.
+const purgeInterval = time.Minute
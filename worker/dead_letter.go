@@ -0,0 +1,83 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"konzek_assg/model"
+)
+
+var ErrAlreadyReplayed = errors.New("dead letter was already replayed")
+
+// deadLetter stores a job whose retries are exhausted so it can be inspected
+// and replayed later.
+func deadLetter(job Job, cause error) {
+	payload, err := json.Marshal(job.Payload)
+	if err != nil {
+		logger.Printf("[%s] Failed to encode dead letter for job %s: %v\n", job.CorrelationID, job.ID, err)
+		return
+	}
+	record := model.DeadLetter{
+		JobID:         job.ID,
+		Operation:     string(job.Operation),
+		Payload:       string(payload),
+		RequesterID:   job.RequesterID,
+		CorrelationID: job.CorrelationID,
+		Track:         job.Track,
+		AnyOwner:      job.AnyOwner,
+		Attempts:      job.Attempts,
+		LastError:     cause.Error(),
+	}
+	if err := model.CreateDeadLetter(&record); err != nil {
+		logger.Printf("[%s] Failed to store dead letter for job %s: %v\n", job.CorrelationID, job.ID, err)
+		return
+	}
+	logger.Printf("[%s] Job %s moved to dead letters after %d attempts: %v\n", job.CorrelationID, job.ID, job.Attempts, cause)
+}
+
+// ReplayDeadLetter queues the dead-lettered job again with a fresh retry
+// budget. The job keeps its ID so a tracked job's status record follows it.
+// The dead letter is claimed before the job is queued, so it is replayed at
+// most once however many requests race for it.
+func ReplayDeadLetter(ctx context.Context, queue Enqueuer, id uint) (Job, error) {
+	record, err := model.FindDeadLetter(id)
+	if err != nil {
+		return Job{}, err
+	}
+	if record.ReplayedAt != nil {
+		return Job{}, ErrAlreadyReplayed
+	}
+
+	var payload model.Task
+	if err := json.Unmarshal([]byte(record.Payload), &payload); err != nil {
+		return Job{}, err
+	}
+	job := Job{
+		ID:            record.JobID,
+		Operation:     Operation(record.Operation),
+		Payload:       payload,
+		RequesterID:   record.RequesterID,
+		CorrelationID: record.CorrelationID,
+		Track:         record.Track,
+		AnyOwner:      record.AnyOwner,
+	}
+
+	claimed, err := model.ClaimDeadLetterReplay(record.ID)
+	if err != nil {
+		return Job{}, err
+	}
+	if !claimed {
+		return Job{}, ErrAlreadyReplayed
+	}
+	if job.Track {
+		markJob(job, model.JobQueued, 0, "")
+	}
+	if err := queue.Enqueue(ctx, job); err != nil {
+		if releaseErr := model.ReleaseDeadLetterReplay(record.ID); releaseErr != nil {
+			logger.Printf("[%s] Failed to release dead letter %d after a failed replay: %v\n", job.CorrelationID, record.ID, releaseErr)
+		}
+		return Job{}, err
+	}
+	logger.Printf("[%s] Dead letter %d replayed as job %s.\n", job.CorrelationID, record.ID, job.ID)
+	return job, nil
+}
@@ -0,0 +1,10 @@
+package worker
+
+import "errors"
+
+// Errors returned by the task operations so callers can tell failures apart.
+var (
+	ErrValidation   = errors.New("validation failed")
+	ErrTaskNotFound = errors.New("task not found")
+	ErrForbidden    = errors.New("forbidden")
+)
@@ -0,0 +1,99 @@
+package worker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"konzek_assg/model"
+	"time"
+)
+
+// Operation is the action a worker performs for a job.
+type Operation string
+
+const (
+	OperationCreate Operation = "create"
+	OperationRead   Operation = "read"
+	OperationUpdate Operation = "update"
+	OperationDelete Operation = "delete"
+)
+
+// Job is the envelope handed to the worker pool. The payload is the task the
+// operation applies to; its Status is the task's lifecycle status and is never
+// used to select the operation.
+type Job struct {
+	ID            string
+	Operation     Operation
+	Payload       model.Task
+	RequesterID   uint
+	CorrelationID string
+	// Attempts counts deliveries of the job, including the current one.
+	Attempts int
+	// EnqueuedAt is set by the queue and used to measure queueing latency.
+	EnqueuedAt time.Time
+	// Track records the job's progress in the jobs table so it can be polled
+	// after the submitter has returned.
+	Track bool
+	// AnyOwner lets an update or delete touch a task of another user. It is
+	// set for requesters holding the tasks:write:any permission.
+	AnyOwner bool
+	Reply    chan Result
+}
+
+// Result is the outcome of a job as seen by whoever submitted it.
+type Result struct {
+	Task  model.Task
+	Tasks []model.Task
+	Err   error
+}
+
+// NewJob builds a job for the given requester. An empty correlationID is
+// replaced with the job's own ID.
+func NewJob(op Operation, payload model.Task, requesterID uint, correlationID string) Job {
+	id := newID()
+	if correlationID == "" {
+		correlationID = id
+	}
+	return Job{
+		ID:            id,
+		Operation:     op,
+		Payload:       payload,
+		RequesterID:   requesterID,
+		CorrelationID: correlationID,
+		Reply:         make(chan Result, 1),
+	}
+}
+
+// reply delivers the result without blocking; the buffered channel lets the
+// worker move on even if the submitter already gave up waiting.
+func (job Job) reply(result Result) {
+	if job.Reply == nil {
+		return
+	}
+	select {
+	case job.Reply <- result:
+	default:
+	}
+}
+
+// Submit hands the job to the pool and waits for its result until ctx is done.
+func Submit(ctx context.Context, queue Enqueuer, job Job) (Result, error) {
+	if err := queue.Enqueue(ctx, job); err != nil {
+		return Result{}, err
+	}
+
+	select {
+	case result := <-job.Reply:
+		return result, nil
+	case <-ctx.Done():
+		return Result{}, ctx.Err()
+	}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
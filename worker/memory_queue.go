@@ -0,0 +1,191 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryQueue is a bounded in-process queue. Jobs are kept in one FIFO per
+// requester and dequeued round-robin across requesters, so a user with many
+// queued jobs cannot starve the others. Jobs are lost if the process exits.
+type MemoryQueue struct {
+	mu       sync.Mutex
+	capacity int
+	size     int
+	queues   map[uint][]Job
+	// order lists the requesters with queued jobs; next is the position of
+	// the requester to serve next.
+	order []uint
+	next  int
+	// delayed holds the jobs waiting out a retry delay, by timer, until they
+	// are queued again or flushed by Close.
+	delayed     map[uint64]Job
+	nextDelayed uint64
+
+	ready     chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func NewMemoryQueue(capacity int) *MemoryQueue {
+	return &MemoryQueue{
+		capacity: capacity,
+		queues:   make(map[uint][]Job),
+		delayed:  make(map[uint64]Job),
+		ready:    make(chan struct{}, 1),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (q *MemoryQueue) Enqueue(ctx context.Context, job Job) error {
+	return q.push(job, false)
+}
+
+// push adds the job to its requester's FIFO. Redelivered jobs are let in even
+// when the queue is full, since they were already accepted once.
+func (q *MemoryQueue) push(job Job, redelivery bool) error {
+	select {
+	case <-q.closed:
+		return ErrQueueClosed
+	default:
+	}
+
+	q.mu.Lock()
+	if !redelivery && q.capacity > 0 && q.size >= q.capacity {
+		q.mu.Unlock()
+		return ErrQueueFull
+	}
+	q.insertLocked(job)
+	q.mu.Unlock()
+
+	q.signal()
+	return nil
+}
+
+func (q *MemoryQueue) insertLocked(job Job) {
+	job.EnqueuedAt = time.Now()
+	if len(q.queues[job.RequesterID]) == 0 {
+		q.order = append(q.order, job.RequesterID)
+	}
+	q.queues[job.RequesterID] = append(q.queues[job.RequesterID], job)
+	q.size++
+}
+
+func (q *MemoryQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop takes the oldest job of the next requester in round-robin order.
+func (q *MemoryQueue) pop() (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.size == 0 {
+		return Job{}, false
+	}
+
+	if q.next >= len(q.order) {
+		q.next = 0
+	}
+	requester := q.order[q.next]
+	jobs := q.queues[requester]
+	job := jobs[0]
+	if len(jobs) == 1 {
+		delete(q.queues, requester)
+		q.order = append(q.order[:q.next], q.order[q.next+1:]...)
+	} else {
+		q.queues[requester] = jobs[1:]
+		q.next++
+	}
+	q.size--
+
+	if q.size > 0 {
+		// Wake another waiting worker for the remaining jobs.
+		q.signal()
+	}
+	job.Attempts++
+	return job, true
+}
+
+func (q *MemoryQueue) Dequeue(ctx context.Context) (Job, error) {
+	for {
+		if job, ok := q.pop(); ok {
+			return job, nil
+		}
+
+		select {
+		case <-q.ready:
+		case <-ctx.Done():
+			return Job{}, ctx.Err()
+		case <-q.closed:
+			if job, ok := q.pop(); ok {
+				return job, nil
+			}
+			return Job{}, ErrQueueClosed
+		}
+	}
+}
+
+func (q *MemoryQueue) Ack(ctx context.Context, job Job) error {
+	return nil
+}
+
+// Nack queues the job again after delay. A job still waiting when the queue
+// is closed is queued at once by Close, so the drain runs it instead of
+// losing it.
+func (q *MemoryQueue) Nack(ctx context.Context, job Job, delay time.Duration) error {
+	if delay <= 0 {
+		return q.push(job, true)
+	}
+
+	q.mu.Lock()
+	select {
+	case <-q.closed:
+		q.mu.Unlock()
+		return ErrQueueClosed
+	default:
+	}
+	id := q.nextDelayed
+	q.nextDelayed++
+	q.delayed[id] = job
+	q.mu.Unlock()
+
+	time.AfterFunc(delay, func() {
+		q.mu.Lock()
+		job, ok := q.delayed[id]
+		if ok {
+			delete(q.delayed, id)
+			q.insertLocked(job)
+		}
+		q.mu.Unlock()
+		if ok {
+			q.signal()
+		}
+	})
+	return nil
+}
+
+func (q *MemoryQueue) Len(ctx context.Context) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size, nil
+}
+
+// Close stops accepting jobs and queues the delayed retries right away, so
+// draining workers still see them.
+func (q *MemoryQueue) Close() error {
+	q.closeOnce.Do(func() {
+		q.mu.Lock()
+		for id, job := range q.delayed {
+			delete(q.delayed, id)
+			q.insertLocked(job)
+		}
+		close(q.closed)
+		q.mu.Unlock()
+		q.signal()
+	})
+	return nil
+}
@@ -0,0 +1,446 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dequeueRetryDelay is how long a worker backs off after the queue fails.
+const dequeueRetryDelay = time.Second
+
+var (
+	ErrInvalidPoolSize = errors.New("invalid pool size")
+	ErrTooManyJobs     = errors.New("too many jobs in flight")
+	ErrPoolStopped     = errors.New("worker pool is shut down")
+)
+
+// LimitError rejects a job because its requester already has the maximum
+// number of jobs queued or running. It matches ErrTooManyJobs.
+type LimitError struct {
+	RequesterID uint
+	RetryAfter  time.Duration
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%v for user %d, retry after %v", ErrTooManyJobs, e.RequesterID, e.RetryAfter)
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrTooManyJobs
+}
+
+var (
+	BusyWorkers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "konzek_assignment_worker_pool_busy_workers",
+		Help: "Number of workers currently processing a job.",
+	})
+	IdleWorkers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "konzek_assignment_worker_pool_idle_workers",
+		Help: "Number of workers waiting for a job.",
+	})
+	QueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "konzek_assignment_worker_pool_queue_depth",
+		Help: "Number of jobs waiting in the queue.",
+	})
+)
+
+// PoolConfig sizes the worker pool and tunes its autoscaling.
+type PoolConfig struct {
+	MinWorkers    int
+	MaxWorkers    int
+	QueueCapacity int
+	// ScaleInterval is how often the pool checks whether to grow.
+	ScaleInterval time.Duration
+	// ScaleUpLatency grows the pool when jobs wait longer than this before a
+	// worker picks them up.
+	ScaleUpLatency time.Duration
+	// IdleTimeout retires a worker above MinWorkers that has waited this long
+	// without receiving a job.
+	IdleTimeout time.Duration
+	// MaxInFlightPerUser caps the jobs a single requester may have queued or
+	// running at once; 0 disables the cap.
+	MaxInFlightPerUser int
+	// RetryAfter is suggested to requesters rejected by MaxInFlightPerUser.
+	RetryAfter time.Duration
+}
+
+var DefaultPoolConfig = PoolConfig{
+	MinWorkers:     5,
+	MaxWorkers:     20,
+	QueueCapacity:  100,
+	ScaleInterval:  time.Second,
+	ScaleUpLatency: 500 * time.Millisecond,
+	IdleTimeout:    30 * time.Second,
+
+	MaxInFlightPerUser: 10,
+	RetryAfter:         time.Second,
+}
+
+// PoolStats is a snapshot of the pool for the admin endpoint.
+type PoolStats struct {
+	MinWorkers    int     `json:"min_workers"`
+	MaxWorkers    int     `json:"max_workers"`
+	QueueCapacity int     `json:"queue_capacity"`
+	Workers       int     `json:"workers"`
+	Busy          int     `json:"busy"`
+	Idle          int     `json:"idle"`
+	QueueDepth    int     `json:"queue_depth"`
+	LatencyMillis float64 `json:"latency_ms"`
+}
+
+// Pool runs a variable number of workers against a queue. It starts with
+// MinWorkers, adds workers while jobs pile up or wait too long, and retires
+// idle workers down to MinWorkers again.
+type Pool struct {
+	queue Queue
+
+	mu      sync.Mutex
+	config  PoolConfig
+	nextID  int
+	workers map[int]context.CancelFunc
+	busy    int
+	// inFlight counts the unsettled jobs of each requester, and admitted
+	// maps the IDs of those jobs to their requester.
+	inFlight map[uint]int
+	admitted map[string]uint
+	// latency is an exponentially weighted average of the time jobs wait in
+	// the queue.
+	latency time.Duration
+
+	wg       sync.WaitGroup
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewPool(queue Queue, config PoolConfig) (*Pool, error) {
+	if err := validatePoolSize(config.MinWorkers, config.MaxWorkers); err != nil {
+		return nil, err
+	}
+	if config.ScaleInterval <= 0 {
+		config.ScaleInterval = DefaultPoolConfig.ScaleInterval
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = DefaultPoolConfig.IdleTimeout
+	}
+	if config.RetryAfter <= 0 {
+		config.RetryAfter = DefaultPoolConfig.RetryAfter
+	}
+	return &Pool{
+		queue:    queue,
+		config:   config,
+		workers:  make(map[int]context.CancelFunc),
+		inFlight: make(map[uint]int),
+		admitted: make(map[string]uint),
+		stop:     make(chan struct{}),
+	}, nil
+}
+
+func validatePoolSize(min, max int) error {
+	if min < 1 || max < min {
+		return fmt.Errorf("%w: need 1 <= min_workers <= max_workers, got %d and %d", ErrInvalidPoolSize, min, max)
+	}
+	return nil
+}
+
+// Start launches the minimum number of workers and the autoscaler.
+func (p *Pool) Start() {
+	p.mu.Lock()
+	p.spawnLocked(p.config.MinWorkers)
+	p.mu.Unlock()
+	go p.autoscale()
+}
+
+// Enqueue hands the job to the queue unless it already holds QueueCapacity
+// jobs or the requester has MaxInFlightPerUser jobs that are not settled yet.
+func (p *Pool) Enqueue(ctx context.Context, job Job) error {
+	if err := p.admit(job); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	capacity := p.config.QueueCapacity
+	p.mu.Unlock()
+
+	if capacity > 0 {
+		depth, err := p.queue.Len(ctx)
+		if err != nil {
+			p.release(job)
+			return err
+		}
+		if depth >= capacity {
+			p.release(job)
+			return ErrQueueFull
+		}
+	}
+	if err := p.queue.Enqueue(ctx, job); err != nil {
+		p.release(job)
+		return err
+	}
+	return nil
+}
+
+func (p *Pool) admit(job Job) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.admitted[job.ID]; ok {
+		return nil
+	}
+	limit := p.config.MaxInFlightPerUser
+	if limit > 0 && p.inFlight[job.RequesterID] >= limit {
+		return &LimitError{RequesterID: job.RequesterID, RetryAfter: p.config.RetryAfter}
+	}
+	p.admitted[job.ID] = job.RequesterID
+	p.inFlight[job.RequesterID]++
+	return nil
+}
+
+// release settles the job's admission. Jobs that were never admitted by
+// this process, e.g. queued before a restart, are ignored, so they cannot
+// take a slot from jobs that were.
+func (p *Pool) release(job Job) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	requesterID, ok := p.admitted[job.ID]
+	if !ok {
+		return
+	}
+	delete(p.admitted, job.ID)
+	if p.inFlight[requesterID] <= 1 {
+		delete(p.inFlight, requesterID)
+		return
+	}
+	p.inFlight[requesterID]--
+}
+
+// Submit enqueues the job and waits for its result until ctx is done.
+func (p *Pool) Submit(ctx context.Context, job Job) (Result, error) {
+	return Submit(ctx, p, job)
+}
+
+// Resize changes the bounds of the pool, starting or retiring workers to fit.
+// It fails with ErrPoolStopped once Shutdown was called.
+func (p *Pool) Resize(min, max int) error {
+	if err := validatePoolSize(min, max); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stoppedLocked() {
+		return ErrPoolStopped
+	}
+
+	p.config.MinWorkers = min
+	p.config.MaxWorkers = max
+	if len(p.workers) < min {
+		p.spawnLocked(min - len(p.workers))
+	}
+	for id, cancel := range p.workers {
+		if len(p.workers) <= max {
+			break
+		}
+		cancel()
+		delete(p.workers, id)
+	}
+	logger.Printf("Worker pool resized to min %d, max %d.\n", min, max)
+	return nil
+}
+
+func (p *Pool) Stats(ctx context.Context) PoolStats {
+	depth, err := p.queue.Len(ctx)
+	if err != nil {
+		logger.Println("Failed to read queue depth:", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return PoolStats{
+		MinWorkers:    p.config.MinWorkers,
+		MaxWorkers:    p.config.MaxWorkers,
+		QueueCapacity: p.config.QueueCapacity,
+		Workers:       len(p.workers),
+		Busy:          p.busy,
+		Idle:          p.idleLocked(),
+		QueueDepth:    depth,
+		LatencyMillis: float64(p.latency) / float64(time.Millisecond),
+	}
+}
+
+// Shutdown closes the queue and waits until the workers have drained it or
+// ctx is done. It may be called more than once, e.g. to wait again after a
+// deadline passed.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.stopOnce.Do(func() {
+		// Closed under the lock, so no worker is started once Shutdown is
+		// waiting for them.
+		p.mu.Lock()
+		close(p.stop)
+		p.mu.Unlock()
+	})
+	if err := p.queue.Close(); err != nil {
+		return err
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) stoppedLocked() bool {
+	select {
+	case <-p.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *Pool) spawnLocked(n int) {
+	for i := 0; i < n; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		id := p.nextID
+		p.nextID++
+		p.workers[id] = cancel
+		p.wg.Add(1)
+		go p.work(ctx, id)
+	}
+}
+
+// retireIfIdle removes the worker if the pool is above its minimum size.
+func (p *Pool) retireIfIdle(id int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cancel, ok := p.workers[id]
+	if !ok {
+		// Already retired by Resize.
+		return true
+	}
+	if len(p.workers) <= p.config.MinWorkers {
+		return false
+	}
+	cancel()
+	delete(p.workers, id)
+	return true
+}
+
+func (p *Pool) work(ctx context.Context, id int) {
+	defer p.wg.Done()
+	for ctx.Err() == nil {
+		p.mu.Lock()
+		idleTimeout := p.config.IdleTimeout
+		p.mu.Unlock()
+
+		dequeueCtx, cancel := context.WithTimeout(ctx, idleTimeout)
+		job, err := p.queue.Dequeue(dequeueCtx)
+		cancel()
+
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrQueueClosed), ctx.Err() != nil:
+			return
+		case errors.Is(err, context.DeadlineExceeded):
+			if p.retireIfIdle(id) {
+				return
+			}
+			continue
+		default:
+			logger.Println("Failed to dequeue job:", err)
+			time.Sleep(dequeueRetryDelay)
+			continue
+		}
+
+		p.begin(job)
+		// Settling uses a fresh context so retiring a worker never
+		// interrupts the job it is running.
+		if handle(context.Background(), p.queue, job) {
+			p.release(job)
+		}
+		p.end()
+	}
+}
+
+func (p *Pool) begin(job Job) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.busy++
+	if !job.EnqueuedAt.IsZero() {
+		wait := time.Since(job.EnqueuedAt)
+		p.latency += (wait - p.latency) / 5
+	}
+	p.updateGaugesLocked()
+}
+
+func (p *Pool) end() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.busy--
+	p.updateGaugesLocked()
+}
+
+// idleLocked counts idle workers. A worker retired while busy no longer
+// counts towards the pool but still towards busy until its job is done.
+func (p *Pool) idleLocked() int {
+	if idle := len(p.workers) - p.busy; idle > 0 {
+		return idle
+	}
+	return 0
+}
+
+func (p *Pool) updateGaugesLocked() {
+	BusyWorkers.Set(float64(p.busy))
+	IdleWorkers.Set(float64(p.idleLocked()))
+}
+
+// autoscale periodically grows the pool while every worker is busy and jobs
+// are waiting, or while jobs wait longer than ScaleUpLatency.
+func (p *Pool) autoscale() {
+	ticker := time.NewTicker(p.config.ScaleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+
+		depth, err := p.queue.Len(context.Background())
+		if err != nil {
+			logger.Println("Failed to read queue depth:", err)
+			continue
+		}
+		QueueDepth.Set(float64(depth))
+
+		p.mu.Lock()
+		if depth == 0 {
+			// Nothing waits, so the measured latency no longer applies.
+			p.latency = 0
+		}
+		saturated := depth > 0 && p.busy >= len(p.workers)
+		slow := p.config.ScaleUpLatency > 0 && p.latency > p.config.ScaleUpLatency
+		if (saturated || slow) && len(p.workers) < p.config.MaxWorkers && !p.stoppedLocked() {
+			n := depth
+			if n < 1 {
+				n = 1
+			}
+			if room := p.config.MaxWorkers - len(p.workers); n > room {
+				n = room
+			}
+			p.spawnLocked(n)
+			logger.Printf("Worker pool scaled up by %d to %d workers (queue depth %d, latency %v).\n", n, len(p.workers), depth, p.latency)
+		}
+		p.updateGaugesLocked()
+		p.mu.Unlock()
+	}
+}
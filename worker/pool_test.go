@@ -0,0 +1,146 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"konzek_assg/model"
+)
+
+func TestPoolSubmitAndResize(t *testing.T) {
+	SetLogger(log.New(io.Discard, "", 0))
+
+	config := DefaultPoolConfig
+	config.MinWorkers = 1
+	config.MaxWorkers = 2
+	pool, err := NewPool(NewMemoryQueue(config.QueueCapacity), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// An unknown operation fails validation without touching the database.
+	job := NewJob("archive", model.Task{}, 1, "")
+	result, err := pool.Submit(ctx, job)
+	if err != nil {
+		t.Fatalf("submit failed: %v", err)
+	}
+	if !errors.Is(result.Err, ErrValidation) {
+		t.Errorf("got error %v, want ErrValidation", result.Err)
+	}
+
+	if err := pool.Resize(3, 2); !errors.Is(err, ErrInvalidPoolSize) {
+		t.Errorf("got %v, want ErrInvalidPoolSize", err)
+	}
+	if err := pool.Resize(3, 4); err != nil {
+		t.Fatal(err)
+	}
+	if stats := pool.Stats(ctx); stats.Workers != 3 {
+		t.Errorf("got %d workers, want 3", stats.Workers)
+	}
+
+	if err := pool.Shutdown(ctx); err != nil {
+		t.Errorf("shutdown failed: %v", err)
+	}
+	if err := pool.Shutdown(ctx); err != nil {
+		t.Errorf("second shutdown failed: %v", err)
+	}
+	if err := pool.Resize(1, 2); !errors.Is(err, ErrPoolStopped) {
+		t.Errorf("resize after shutdown: got %v, want ErrPoolStopped", err)
+	}
+	if err := pool.Enqueue(ctx, job); !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("got %v, want ErrQueueClosed", err)
+	}
+}
+
+func TestMemoryQueueRoundRobin(t *testing.T) {
+	queue := NewMemoryQueue(10)
+	ctx := context.Background()
+	for _, requester := range []uint{1, 1, 1, 2, 3} {
+		if err := queue.Enqueue(ctx, NewJob(OperationRead, model.Task{}, requester, "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []uint
+	for i := 0; i < 5; i++ {
+		job, err := queue.Dequeue(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, job.RequesterID)
+	}
+	want := []uint{1, 2, 3, 1, 1}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got order %v, want %v", got, want)
+		}
+	}
+}
+
+func TestMemoryQueueCloseFlushesDelayedRetries(t *testing.T) {
+	queue := NewMemoryQueue(10)
+	ctx := context.Background()
+	job := NewJob(OperationRead, model.Task{}, 1, "")
+	if err := queue.Nack(ctx, job, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	queue.Close()
+
+	got, err := queue.Dequeue(ctx)
+	if err != nil || got.ID != job.ID {
+		t.Fatalf("Dequeue after Close = %v, %v; want the delayed job", got.ID, err)
+	}
+	if _, err := queue.Dequeue(ctx); !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("drained queue: err = %v", err)
+	}
+	if err := queue.Nack(ctx, job, time.Hour); !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("Nack on a closed queue: err = %v", err)
+	}
+}
+
+func TestPoolLimitsJobsPerUser(t *testing.T) {
+	SetLogger(log.New(io.Discard, "", 0))
+
+	config := DefaultPoolConfig
+	config.MaxInFlightPerUser = 2
+	pool, err := NewPool(NewMemoryQueue(config.QueueCapacity), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The pool is not started, so admitted jobs stay in flight.
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := pool.Enqueue(ctx, NewJob(OperationRead, model.Task{}, 7, "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = pool.Enqueue(ctx, NewJob(OperationRead, model.Task{}, 7, ""))
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || !errors.Is(err, ErrTooManyJobs) {
+		t.Fatalf("got %v, want a LimitError", err)
+	}
+	if limitErr.RetryAfter != config.RetryAfter {
+		t.Errorf("got Retry-After %v, want %v", limitErr.RetryAfter, config.RetryAfter)
+	}
+	if err := pool.Enqueue(ctx, NewJob(OperationRead, model.Task{}, 8, "")); err != nil {
+		t.Errorf("other users must not be limited: %v", err)
+	}
+
+	// Settling jobs this pool never admitted, e.g. queued before a restart,
+	// frees no slot.
+	for i := 0; i < 3; i++ {
+		pool.release(NewJob(OperationRead, model.Task{}, 7, ""))
+	}
+	if err := pool.Enqueue(ctx, NewJob(OperationRead, model.Task{}, 7, "")); !errors.Is(err, ErrTooManyJobs) {
+		t.Errorf("settling unknown jobs freed a slot: got %v, want ErrTooManyJobs", err)
+	}
+}
@@ -0,0 +1,210 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"konzek_assg/model"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultPollInterval      = time.Second
+	defaultVisibilityTimeout = 5 * time.Minute
+)
+
+// PostgresQueue persists jobs in the queued_jobs table. A dequeued row is
+// leased for the visibility timeout; if it is not acknowledged in time, for
+// example because the process crashed, another worker picks it up again.
+//
+// Reply channels cannot be persisted, so they are kept in memory and
+// reattached when the job is dequeued by the same process.
+type PostgresQueue struct {
+	db                *gorm.DB
+	pollInterval      time.Duration
+	visibilityTimeout time.Duration
+
+	mu      sync.Mutex
+	replies map[string]chan Result
+
+	notify    chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func NewPostgresQueue(db *gorm.DB, pollInterval, visibilityTimeout time.Duration) *PostgresQueue {
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+	if visibilityTimeout <= 0 {
+		visibilityTimeout = defaultVisibilityTimeout
+	}
+	return &PostgresQueue{
+		db:                db,
+		pollInterval:      pollInterval,
+		visibilityTimeout: visibilityTimeout,
+		replies:           make(map[string]chan Result),
+		notify:            make(chan struct{}, 1),
+		closed:            make(chan struct{}),
+	}
+}
+
+func (q *PostgresQueue) Enqueue(ctx context.Context, job Job) error {
+	select {
+	case <-q.closed:
+		return ErrQueueClosed
+	default:
+	}
+
+	payload, err := json.Marshal(job.Payload)
+	if err != nil {
+		return err
+	}
+	row := model.QueuedJob{
+		ID:            job.ID,
+		Operation:     string(job.Operation),
+		Payload:       string(payload),
+		RequesterID:   job.RequesterID,
+		CorrelationID: job.CorrelationID,
+		Track:         job.Track,
+		AnyOwner:      job.AnyOwner,
+		Attempts:      job.Attempts,
+		AvailableAt:   time.Now(),
+	}
+	if err := q.db.WithContext(ctx).Create(&row).Error; err != nil {
+		return err
+	}
+
+	if job.Reply != nil {
+		q.mu.Lock()
+		q.replies[job.ID] = job.Reply
+		q.mu.Unlock()
+	}
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (q *PostgresQueue) Dequeue(ctx context.Context) (Job, error) {
+	for {
+		job, err := q.claim(ctx)
+		if err == nil {
+			return job, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return Job{}, err
+		}
+
+		select {
+		case <-q.closed:
+			return Job{}, ErrQueueClosed
+		default:
+		}
+
+		select {
+		case <-q.notify:
+		case <-time.After(q.pollInterval):
+		case <-q.closed:
+		case <-ctx.Done():
+			return Job{}, ctx.Err()
+		}
+	}
+}
+
+// claim leases an available row, skipping rows other workers hold. Rows of
+// requesters with the fewest jobs currently leased go first, so one busy
+// requester cannot monopolise the workers; ties go to the oldest row.
+func (q *PostgresQueue) claim(ctx context.Context) (Job, error) {
+	var row model.QueuedJob
+	err := q.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Clauses(
+			clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"},
+			clause.OrderBy{Expression: clause.Expr{
+				SQL:                "(SELECT count(*) FROM queued_jobs AS leased WHERE leased.requester_id = queued_jobs.requester_id AND leased.locked_until >= ?), available_at, created_at",
+				Vars:               []interface{}{now},
+				WithoutParentheses: true,
+			}},
+		).
+			Where("available_at <= ? AND (locked_until IS NULL OR locked_until < ?)", now, now).
+			Limit(1).
+			Find(&row).Error
+		if err == nil && row.ID == "" {
+			err = gorm.ErrRecordNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		lockedUntil := now.Add(q.visibilityTimeout)
+		row.LockedUntil = &lockedUntil
+		row.Attempts++
+		return tx.Model(&row).Updates(map[string]interface{}{
+			"locked_until": lockedUntil,
+			"attempts":     row.Attempts,
+		}).Error
+	})
+	if err != nil {
+		return Job{}, err
+	}
+
+	var payload model.Task
+	if err := json.Unmarshal([]byte(row.Payload), &payload); err != nil {
+		return Job{}, err
+	}
+
+	q.mu.Lock()
+	reply := q.replies[row.ID]
+	q.mu.Unlock()
+
+	return Job{
+		ID:            row.ID,
+		Operation:     Operation(row.Operation),
+		Payload:       payload,
+		RequesterID:   row.RequesterID,
+		CorrelationID: row.CorrelationID,
+		Attempts:      row.Attempts,
+		EnqueuedAt:    row.AvailableAt,
+		Track:         row.Track,
+		AnyOwner:      row.AnyOwner,
+		Reply:         reply,
+	}, nil
+}
+
+func (q *PostgresQueue) Ack(ctx context.Context, job Job) error {
+	q.mu.Lock()
+	delete(q.replies, job.ID)
+	q.mu.Unlock()
+
+	return q.db.WithContext(ctx).Delete(&model.QueuedJob{}, "id = ?", job.ID).Error
+}
+
+func (q *PostgresQueue) Nack(ctx context.Context, job Job, delay time.Duration) error {
+	return q.db.WithContext(ctx).Model(&model.QueuedJob{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+		"locked_until": nil,
+		"available_at": time.Now().Add(delay),
+	}).Error
+}
+
+func (q *PostgresQueue) Len(ctx context.Context) (int, error) {
+	var count int64
+	now := time.Now()
+	err := q.db.WithContext(ctx).Model(&model.QueuedJob{}).
+		Where("available_at <= ? AND (locked_until IS NULL OR locked_until < ?)", now, now).
+		Count(&count).Error
+	return int(count), err
+}
+
+func (q *PostgresQueue) Close() error {
+	q.closeOnce.Do(func() {
+		close(q.closed)
+	})
+	return nil
+}
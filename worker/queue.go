@@ -0,0 +1,40 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrQueueClosed = errors.New("queue closed")
+	ErrQueueFull   = errors.New("queue is full")
+)
+
+// Enqueuer is anything jobs can be handed to: a Queue or a Pool in front of
+// one.
+type Enqueuer interface {
+	Enqueue(ctx context.Context, job Job) error
+}
+
+// Queue carries jobs from the HTTP handlers to the workers. A dequeued job
+// stays owned by the queue until it is acknowledged; a job that is neither
+// acknowledged nor negatively acknowledged, for example because the process
+// died, is delivered again by durable implementations.
+type Queue interface {
+	// Enqueue stores the job. Durable implementations persist it before
+	// returning.
+	Enqueue(ctx context.Context, job Job) error
+	// Dequeue blocks until a job is available, ctx is done or the queue is
+	// closed and drained, in which case it returns ErrQueueClosed.
+	Dequeue(ctx context.Context) (Job, error)
+	// Ack removes a processed job from the queue.
+	Ack(ctx context.Context, job Job) error
+	// Nack returns the job to the queue to be delivered again after delay.
+	Nack(ctx context.Context, job Job, delay time.Duration) error
+	// Len returns the number of jobs waiting to be dequeued.
+	Len(ctx context.Context) (int, error)
+	// Close stops accepting new jobs. Jobs already queued can still be
+	// dequeued.
+	Close() error
+}
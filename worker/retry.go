@@ -0,0 +1,92 @@
+package worker
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrPermanent marks an error that retrying cannot fix.
+var ErrPermanent = errors.New("permanent failure")
+
+// RetryPolicy controls how often and how quickly a failed job is retried.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter is the fraction of the backoff, between 0 and 1, that is
+	// randomised so retries of many jobs do not line up.
+	Jitter float64
+}
+
+var defaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 200 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+var (
+	retryPolicyLock sync.RWMutex
+	retryPolicies   = map[Operation]RetryPolicy{
+		OperationCreate: defaultRetryPolicy,
+		OperationUpdate: defaultRetryPolicy,
+		OperationDelete: defaultRetryPolicy,
+		// Reads are answered to a waiting handler; retrying them past its
+		// deadline is pointless.
+		OperationRead: {MaxAttempts: 2, InitialBackoff: 100 * time.Millisecond, MaxBackoff: 100 * time.Millisecond, Multiplier: 1},
+	}
+)
+
+func SetRetryPolicy(op Operation, policy RetryPolicy) {
+	retryPolicyLock.Lock()
+	defer retryPolicyLock.Unlock()
+	retryPolicies[op] = policy
+}
+
+func RetryPolicyFor(op Operation) RetryPolicy {
+	retryPolicyLock.RLock()
+	defer retryPolicyLock.RUnlock()
+	if policy, ok := retryPolicies[op]; ok {
+		return policy
+	}
+	return RetryPolicy{MaxAttempts: 1}
+}
+
+// Backoff returns the delay before the attempt following the given one.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	backoff := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		backoff += backoff * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(backoff)
+}
+
+// IsRetryable reports whether a failed job may succeed if run again. Invalid
+// input, missing tasks and authorization failures are permanent; anything
+// else, typically a database error, is assumed to be transient.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, permanent := range []error{ErrPermanent, ErrValidation, ErrTaskNotFound, ErrForbidden} {
+		if errors.Is(err, permanent) {
+			return false
+		}
+	}
+	return true
+}
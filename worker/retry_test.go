@@ -0,0 +1,45 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+	}
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second}
+	for i, want := range expected {
+		if got := policy.Backoff(i + 1); got != want {
+			t.Errorf("attempt %d: got %v want %v", i+1, got, want)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		got := policy.Backoff(1)
+		if got < 50*time.Millisecond || got > 150*time.Millisecond {
+			t.Fatalf("jittered backoff %v outside of [50ms, 150ms]", got)
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	if IsRetryable(nil) {
+		t.Errorf("nil error must not be retryable")
+	}
+	if !IsRetryable(errors.New("connection reset")) {
+		t.Errorf("database errors must be retryable")
+	}
+	for _, permanent := range []error{ErrValidation, ErrTaskNotFound, ErrForbidden, ErrPermanent} {
+		if IsRetryable(fmt.Errorf("wrapped: %w", permanent)) {
+			t.Errorf("%v must not be retryable", permanent)
+		}
+	}
+}
@@ -1,79 +1,126 @@
 package worker
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"konzek_assg/database"
 	"konzek_assg/model"
 
 	"log"
-	"sync"
 	"time"
 
 	"gorm.io/gorm"
 )
 
-var (
-	logger         *log.Logger
-	mutex          sync.Mutex
-	tasks          = make(map[uint]model.Task)
-	taskResultLock sync.Mutex
-	taskResults    = make(map[uint][]model.Task)
-)
-
-func SetTasks(t map[uint]model.Task) {
-	tasks = t
-}
-func SetTaskResults(tr map[uint][]model.Task) {
-	taskResultLock.Lock()
-	defer taskResultLock.Unlock()
-	taskResults = tr
-}
+var logger *log.Logger
 
 func SetLogger(l *log.Logger) {
 	logger = l
 }
 
-func Work(taskCh <-chan model.Task, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for task := range taskCh {
-		switch task.Status {
-		case "create":
-			err := CreateTask(task, logger)
-
-			if err != nil {
-				logger.Printf("Failed to create task for user ID %d: %v\n", task.UserID, err)
-			}
-		case "read":
-			tasks, err := ReadTask(task.UserID, logger)
-
-			if err != nil {
-				logger.Printf("Failed to read tasks for user ID %d: %v\n", task.UserID, err)
-			} else {
-				taskResultLock.Lock()
-				taskResults[task.UserID] = tasks
-				taskResultLock.Unlock()
-			}
-		case "delete":
-			err := DeleteTask(task, logger)
-			if err != nil {
-				logger.Printf("Deleting tasks for user ID: %d\n", task.UserID)
+// handle runs a job and settles it with the queue. Transient failures, panics
+// included, are handed back to the queue with a backoff until the operation's
+// retry policy is exhausted, at which point the job is moved to the
+// dead-letter store. It reports whether the job is settled, that is not
+// waiting for another attempt.
+func handle(ctx context.Context, queue Queue, job Job) bool {
+	if job.Track {
+		markJob(job, model.JobRunning, 0, "")
+	}
+	result := safeProcess(job)
+
+	if result.Err != nil && IsRetryable(result.Err) {
+		policy := RetryPolicyFor(job.Operation)
+		if job.Attempts < policy.MaxAttempts {
+			delay := policy.Backoff(job.Attempts)
+			logger.Printf("[%s] Attempt %d of %d for job %s failed, retrying in %v: %v\n", job.CorrelationID, job.Attempts, policy.MaxAttempts, job.ID, delay, result.Err)
+			if job.Track {
+				markJob(job, model.JobQueued, 0, result.Err.Error())
 			}
-		case "update":
-			err := UpdateTask(task, logger)
-			if err != nil {
-				logger.Println("Invalid operation for task")
+			err := queue.Nack(ctx, job, delay)
+			if err == nil {
+				return false
 			}
-		default:
-			logger.Println("Invalid operation for task.")
+			logger.Printf("[%s] Failed to requeue job %s: %v\n", job.CorrelationID, job.ID, err)
+		}
+		deadLetter(job, result.Err)
+	}
+
+	if job.Track {
+		if result.Err != nil {
+			markJob(job, model.JobFailed, result.Task.ID, result.Err.Error())
+		} else {
+			markJob(job, model.JobSucceeded, result.Task.ID, "")
 		}
 	}
+	job.reply(result)
+
+	if err := queue.Ack(ctx, job); err != nil {
+		logger.Printf("[%s] Failed to acknowledge job %s: %v\n", job.CorrelationID, job.ID, err)
+	}
+	return true
+}
+
+func safeProcess(job Job) (result Result) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Printf("[%s] Recovered from panic in job %s: %v\n", job.CorrelationID, job.ID, r)
+			result = Result{Err: fmt.Errorf("panic while processing job: %v", r)}
+		}
+	}()
+	return process(job)
+}
+
+func process(job Job) Result {
+	task := job.Payload
+	switch job.Operation {
+	case OperationCreate:
+		created, err := CreateTask(task, logger)
+		if err != nil {
+			logger.Printf("[%s] Failed to create task for user ID %d: %v\n", job.CorrelationID, job.RequesterID, err)
+		}
+		return Result{Task: created, Err: err}
+	case OperationRead:
+		var tasks []model.Task
+		var err error
+		if task.WorkspaceID != 0 {
+			tasks, err = ReadWorkspaceTasks(task.WorkspaceID, job.RequesterID, logger)
+		} else {
+			tasks, err = ReadTask(job.RequesterID, logger)
+		}
+		if err != nil {
+			logger.Printf("[%s] Failed to read tasks for user ID %d: %v\n", job.CorrelationID, job.RequesterID, err)
+		}
+		return Result{Tasks: tasks, Err: err}
+	case OperationDelete:
+		err := deleteTask(task, job.AnyOwner, logger)
+		if err != nil {
+			logger.Printf("[%s] Failed to delete task %d for user ID %d: %v\n", job.CorrelationID, task.ID, job.RequesterID, err)
+		}
+		return Result{Task: task, Err: err}
+	case OperationUpdate:
+		updated, err := updateTask(task, job.AnyOwner, logger)
+		if err != nil {
+			logger.Printf("[%s] Failed to update task %d for user ID %d: %v\n", job.CorrelationID, task.ID, job.RequesterID, err)
+		}
+		return Result{Task: updated, Err: err}
+	default:
+		logger.Printf("[%s] Invalid operation %q for task.\n", job.CorrelationID, job.Operation)
+		return Result{Err: fmt.Errorf("%w: invalid operation %q", ErrValidation, job.Operation)}
+	}
+}
+
+func markJob(job Job, status model.JobStatus, taskID uint, errMessage string) {
+	if err := model.UpdateJobRecord(job.ID, status, taskID, errMessage); err != nil {
+		logger.Printf("[%s] Failed to mark job %s as %s: %v\n", job.CorrelationID, job.ID, status, err)
+	}
 }
 
 func ReadTask(userId uint, logger *log.Logger) ([]model.Task, error) {
 	if userId == 0 {
 		logger.Println("User id is empty")
-		return nil, errors.New("the user id is empty")
+		return nil, fmt.Errorf("%w: the user id is empty", ErrValidation)
 	}
 
 	tasks, err := model.ReadAllTasksByUserID(userId, logger)
@@ -81,10 +128,15 @@ func ReadTask(userId uint, logger *log.Logger) ([]model.Task, error) {
 		logger.Println("Error in reading user's tasks:", err)
 		return nil, fmt.Errorf("failed to read tasks for user %d: %w", userId, err)
 	}
+	shared, err := model.ReadTasksSharedWith(userId)
+	if err != nil {
+		logger.Println("Error in reading tasks shared with user:", err)
+		return nil, fmt.Errorf("failed to read tasks shared with user %d: %w", userId, err)
+	}
+	tasks = append(tasks, shared...)
 
-	if len(tasks) == 0 {
-		logger.Println("No tasks found for user:", userId)
-		return nil, errors.New("there are no tasks for the given user id")
+	if tasks == nil {
+		tasks = []model.Task{}
 	}
 
 	logger.Printf("Tasks read successfully: %v\n", tasks)
@@ -92,26 +144,131 @@ func ReadTask(userId uint, logger *log.Logger) ([]model.Task, error) {
 	return tasks, nil
 }
 
-func UpdateTask(task model.Task, logger *log.Logger) error {
+// ReadWorkspaceTasks lists the tasks of the workspace for one of its members.
+func ReadWorkspaceTasks(workspaceID, userID uint, logger *log.Logger) ([]model.Task, error) {
+	if err := requireWorkspaceRole(workspaceID, userID, false, logger); err != nil {
+		return nil, err
+	}
+	tasks, err := model.ReadWorkspaceTasks(workspaceID)
+	if err != nil {
+		logger.Println("Error in reading workspace tasks:", err)
+		return nil, fmt.Errorf("failed to read tasks of workspace %d: %w", workspaceID, err)
+	}
+	if tasks == nil {
+		tasks = []model.Task{}
+	}
+	return tasks, nil
+}
+
+// requireWorkspaceRole checks that the user is a member of the workspace and,
+// if write is set, that their role may change its tasks.
+func requireWorkspaceRole(workspaceID, userID uint, write bool, logger *log.Logger) error {
+	role, err := model.FindWorkspaceRole(workspaceID, userID)
+	if errors.Is(err, model.ErrNotWorkspaceMember) {
+		logger.Println("User is not a member of the workspace.")
+		return fmt.Errorf("%w: %w", ErrForbidden, err)
+	}
+	if err != nil {
+		logger.Println("Error in reading workspace membership:", err)
+		return err
+	}
+	if write && !role.CanWriteTasks() {
+		logger.Println("Workspace role cannot change tasks:", role)
+		return fmt.Errorf("%w: workspace %ss cannot change tasks", ErrForbidden, role)
+	}
+	return nil
+}
+
+// findScopedTask loads a task of the given workspace, 0 meaning a personal
+// task. A task of another tenant is reported as missing, so its existence
+// does not leak; anyOwner lifts the restriction.
+func findScopedTask(id, workspaceID uint, anyOwner bool, logger *log.Logger) (model.Task, error) {
+	taskFromDB, err := findTask(id, logger)
+	if err != nil {
+		return model.Task{}, err
+	}
+	if !anyOwner && taskFromDB.WorkspaceID != workspaceID {
+		logger.Println("Task belongs to another workspace.")
+		return model.Task{}, ErrTaskNotFound
+	}
+	return taskFromDB, nil
+}
+
+// findTask loads a task, translating a missing row into ErrTaskNotFound.
+func findTask(id uint, logger *log.Logger) (model.Task, error) {
+	var taskFromDB model.Task
+	if err := database.Database.First(&taskFromDB, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Println("Task is not found.")
+			return model.Task{}, ErrTaskNotFound
+		}
+		logger.Println("Error in reading task:", err)
+		return model.Task{}, err
+	}
+	return taskFromDB, nil
+}
+
+func UpdateTask(task model.Task, logger *log.Logger) (model.Task, error) {
+	return updateTask(task, false, logger)
+}
+
+// requireEditor allows a collaborator who is not the owner to edit the task
+// if it was shared with them at editor level.
+func requireEditor(taskID, userID uint, logger *log.Logger) error {
+	level, err := model.FindShareLevel(taskID, userID)
+	if err != nil && !errors.Is(err, model.ErrShareNotFound) {
+		logger.Println("Error in reading task share:", err)
+		return err
+	}
+	if level != model.ShareEditor {
+		logger.Println("You are not authorized to update this task.")
+		return fmt.Errorf("%w: you are not authorized to update this task", ErrForbidden)
+	}
+	return nil
+}
+
+// updateTask applies the update; unless anyOwner is set, only the owner of
+// the task and editors it is shared with may change a personal task, and
+// only members with write access a workspace task.
+func updateTask(task model.Task, anyOwner bool, logger *log.Logger) (model.Task, error) {
 	if task.ID == 0 {
 		logger.Println("Task ID is required for updating.")
-		return errors.New("task ID is required for updating")
+		return model.Task{}, fmt.Errorf("%w: task ID is required for updating", ErrValidation)
 	}
 
 	if task.UserID == 0 {
 		logger.Println("User ID is required for updating.")
-		return errors.New("user ID is required for task update")
+		return model.Task{}, fmt.Errorf("%w: user ID is required for task update", ErrValidation)
 	}
 
-	var taskFromDB model.Task
-	if err := database.Database.First(&taskFromDB, task.ID).Error; err != nil {
-		logger.Println("The given task is not founded in the database.")
-		return errors.New("the given task is not founded in the database")
+	taskFromDB, err := findScopedTask(task.ID, task.WorkspaceID, anyOwner, logger)
+	if err != nil {
+		return model.Task{}, err
 	}
 
-	if taskFromDB.UserID != task.UserID {
-		logger.Println("You are not authorized to update this task.")
-		return errors.New("you are not authorized to update this task")
+	switch {
+	case anyOwner:
+	case taskFromDB.WorkspaceID != 0:
+		if err := requireWorkspaceRole(taskFromDB.WorkspaceID, task.UserID, true, logger); err != nil {
+			return model.Task{}, err
+		}
+	case taskFromDB.UserID != task.UserID:
+		if err := requireEditor(task.ID, task.UserID, logger); err != nil {
+			return model.Task{}, err
+		}
+	}
+
+	status := taskFromDB.Status
+	if task.Status != "" {
+		if !task.Status.Valid() {
+			logger.Println("Task status is invalid:", task.Status)
+			return model.Task{}, fmt.Errorf("%w: %w: %q", ErrValidation, model.ErrInvalidStatus, task.Status)
+		}
+		if !taskFromDB.Status.CanTransitionTo(task.Status) {
+			logger.Printf("Task status cannot change from %s to %s.\n", taskFromDB.Status, task.Status)
+			return model.Task{}, fmt.Errorf("%w: %w: %s -> %s", ErrValidation, model.ErrInvalidTransition, taskFromDB.Status, task.Status)
+		}
+		status = task.Status
 	}
 
 	tx := database.Database.Begin()
@@ -120,39 +277,52 @@ func UpdateTask(task model.Task, logger *log.Logger) error {
 	if err := tx.Model(&model.Task{}).Where("id = ?", task.ID).Updates(map[string]interface{}{
 		"title":       task.Title,
 		"description": task.Description,
-		"status":      task.Status,
+		"status":      status,
 	}).Error; err != nil {
 		logger.Println("Error in updating task.")
-		return errors.New("error in updating task")
+		return model.Task{}, errors.New("error in updating task")
 	}
 
 	if err := tx.Commit().Error; err != nil {
 		logger.Println("Error in committing task.")
-		return errors.New("error in committing")
+		return model.Task{}, errors.New("error in committing")
 	}
 	logger.Println("Task is updated successfully.")
-	return nil
+
+	taskFromDB.Title = task.Title
+	taskFromDB.Description = task.Description
+	taskFromDB.Status = status
+	return taskFromDB, nil
 }
 
 func DeleteTask(task model.Task, logger *log.Logger) error {
+	return deleteTask(task, false, logger)
+}
+
+// deleteTask deletes the task; unless anyOwner is set, only the owner of a
+// personal task may, not even editors it is shared with, and only members
+// with write access may delete a workspace task.
+func deleteTask(task model.Task, anyOwner bool, logger *log.Logger) error {
 	userid := task.UserID
 
 	if task.ID == 0 {
 		logger.Println("Task id is required for deletion.")
-		return errors.New("task ID is required for deletion")
+		return fmt.Errorf("%w: task ID is required for deletion", ErrValidation)
 	}
-	var taskFromDB model.Task
-	if err := database.Database.First(&taskFromDB, task.ID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			logger.Println("Task is not found.")
-			return errors.New("task not found")
-		}
+	taskFromDB, err := findScopedTask(task.ID, task.WorkspaceID, anyOwner, logger)
+	if err != nil {
 		return err
 	}
 
-	if taskFromDB.UserID != userid {
+	switch {
+	case anyOwner:
+	case taskFromDB.WorkspaceID != 0:
+		if err := requireWorkspaceRole(taskFromDB.WorkspaceID, userid, true, logger); err != nil {
+			return err
+		}
+	case taskFromDB.UserID != userid:
 		logger.Println("You are not authorized to delete this task.")
-		return errors.New("you are not authorized to delete this task")
+		return fmt.Errorf("%w: you are not authorized to delete this task", ErrForbidden)
 	}
 
 	var deletedTime gorm.DeletedAt
@@ -181,14 +351,22 @@ func DeleteTask(task model.Task, logger *log.Logger) error {
 	return nil
 }
 
-func CreateTask(task model.Task, logger *log.Logger) error {
+func CreateTask(task model.Task, logger *log.Logger) (model.Task, error) {
 	if task.Title == "" {
 		logger.Println("Task title is empty.")
-		return errors.New("the title field shouldn't be empty")
+		return model.Task{}, fmt.Errorf("%w: the title field shouldn't be empty", ErrValidation)
 	}
 	if task.Status == "" {
-		logger.Println("Task status is empty.")
-		return errors.New("the status field shouldn't be empty")
+		task.Status = model.StatusTodo
+	}
+	if !task.Status.Valid() {
+		logger.Println("Task status is invalid:", task.Status)
+		return model.Task{}, fmt.Errorf("%w: %w: %q", ErrValidation, model.ErrInvalidStatus, task.Status)
+	}
+	if task.WorkspaceID != 0 {
+		if err := requireWorkspaceRole(task.WorkspaceID, task.UserID, true, logger); err != nil {
+			return model.Task{}, err
+		}
 	}
 
 	tx := database.Database.Begin()
@@ -199,23 +377,16 @@ func CreateTask(task model.Task, logger *log.Logger) error {
 		}
 	}()
 
-	mutex.Lock()
-	defer mutex.Unlock()
-
-	task.CreatedAt = time.Now()
-
-	if _, err := task.SaveInTransaction(tx, logger); err != nil {
+	if _, err := task.CreateInTransaction(tx, logger); err != nil {
 		tx.Rollback()
 		logger.Println("Failed to save task.")
-		return errors.New("failed to save task")
+		return model.Task{}, errors.New("failed to save task")
 	}
 
-	tasks[task.ID] = task
-
 	if err := tx.Commit().Error; err != nil {
 		logger.Println("Failed to commit transaction:", err)
-		return errors.New("failed to commit transaction")
+		return model.Task{}, errors.New("failed to commit transaction")
 	}
 	logger.Println("Task created successfully.")
-	return nil
+	return task, nil
 }